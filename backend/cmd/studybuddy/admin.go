@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"studybuddy/internal/config"
+	"studybuddy/internal/db"
+)
+
+const adminUsage = `usage: studybuddy admin <command> <email>
+
+commands:
+  grant <email>    make the user a platform admin
+  revoke <email>   make the user a regular user again
+
+Role changes sign the user out so their next login picks up the new role.`
+
+// runAdminCommand handles `studybuddy admin ...`, mainly to create the first
+// platform admin, and returns the exit code
+func runAdminCommand(cfg *config.Config, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, adminUsage)
+		return 2
+	}
+
+	var role string
+	switch args[0] {
+	case "grant":
+		role = db.RoleAdmin
+	case "revoke":
+		role = db.RoleUser
+	default:
+		fmt.Fprintf(os.Stderr, "unknown admin command %q\n\n%s\n", args[0], adminUsage)
+		return 2
+	}
+
+	db.Connect(cfg.DB)
+
+	var userID int
+	var current string
+	err := db.DB.QueryRow(`SELECT id, platform_role FROM users WHERE LOWER(email) = $1`, strings.ToLower(args[1])).
+		Scan(&userID, &current)
+	if err == sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "no user with email %s\n", args[1])
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin %s: %v\n", args[0], err)
+		return 1
+	}
+
+	if err := db.SetPlatformRole(userID, role); err != nil {
+		fmt.Fprintf(os.Stderr, "admin %s: %v\n", args[0], err)
+		return 1
+	}
+	if _, err := db.RevokeUserSessions(userID, 0); err != nil {
+		fmt.Fprintf(os.Stderr, "admin %s: revoke sessions: %v\n", args[0], err)
+	}
+	if err := db.RecordAdminAction(0, "set_role", "user", userID, map[string]interface{}{"from": current, "to": role, "via": "cli"}, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "admin %s: audit log: %v\n", args[0], err)
+	}
+
+	fmt.Printf("%s is now %s\n", args[1], role)
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"studybuddy/internal/ws"
+)
+
+const eventsUsage = `usage: studybuddy events <command>
+
+commands:
+  schema   print the JSON Schema of the realtime event protocol`
+
+// runEventsCommand handles `studybuddy events ...` and returns the exit code
+func runEventsCommand(args []string) int {
+	if len(args) != 1 || args[0] != "schema" {
+		fmt.Fprintln(os.Stderr, eventsUsage)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ws.Schema()); err != nil {
+		fmt.Fprintf(os.Stderr, "events schema: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
-	"strings"
+	"os"
 
 	"studybuddy/internal/api"
+	"studybuddy/internal/auth"
+	"studybuddy/internal/config"
 	"studybuddy/internal/db"
 	"studybuddy/internal/handlers"
-	"studybuddy/internal/models"
+	"studybuddy/internal/mail"
+	"studybuddy/internal/oidc"
 	"studybuddy/internal/ws"
 
 	"github.com/gorilla/mux"
@@ -17,13 +20,64 @@ import (
 )
 
 func main() {
-	db.Init()
+	// needs no config
+	if len(os.Args) > 1 && os.Args[1] == "events" {
+		os.Exit(runEventsCommand(os.Args[2:]))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrateCommand(cfg, os.Args[2:]))
+		case "admin":
+			os.Exit(runAdminCommand(cfg, os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n\n%s\n\n%s\n", os.Args[1], migrateUsage, adminUsage, eventsUsage)
+			os.Exit(2)
+		}
+	}
+
+	db.Init(cfg.DB)
+	auth.Configure(cfg)
+	handlers.Configure(cfg)
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("❌ mailer: %v", err)
+	}
+	handlers.Mailer = mailer
+	if cfg.OIDC.Enabled() {
+		handlers.OIDC = oidc.New(cfg.OIDC)
+	}
+	ws.Configure(cfg)
 
 	hub := ws.NewHub()
+	if cfg.Realtime.Broker == config.BrokerPostgres {
+		broker, err := ws.NewPostgresBroker(db.DB, cfg.DB.ConnString())
+		if err != nil {
+			log.Fatalf("❌ realtime broker: %v", err)
+		}
+		if err := hub.UseBroker(broker); err != nil {
+			log.Fatalf("❌ realtime broker: %v", err)
+		}
+	} else if err := db.ResetPresence(); err != nil {
+		// the only replica: whoever looked online before a restart is gone
+		log.Printf("⚠️ resetting presence: %v", err)
+	}
+	hub.OnPresence = handlers.PresenceChanged
 	go hub.Run()
+	// replicas that stop without closing their connections drop out of
+	// presence when their rows expire
+	go handlers.RunPresenceHeartbeat()
 
-	// Set the global hub reference so handlers can broadcast
+	// the one hub of the realtime gateway; handlers broadcast through it
 	handlers.GlobalHub = hub
+	db.NotificationCreated = handlers.PushNotification
+	db.RankChanged = handlers.PushRankChange
 
 	r := mux.NewRouter()
 	api.RegisterRoutes(r)
@@ -31,58 +85,16 @@ func main() {
 	// serve uploaded files from ./uploads under /uploads/
 	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads/"))))
 
-	r.HandleFunc("/ws/{groupID}", func(w http.ResponseWriter, r *http.Request) {
-		ws.ServeWS(hub, w, r)
-	})
-
 	// Enable CORS
 	c := cors.New(cors.Options{
-		// allow both common vite dev ports (5173 and 5174) during development and Docker frontend
-		AllowedOrigins: []string{"http://localhost:5173", "http://localhost:5174", "http://localhost:3000", "http://frontend:3000"},
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	})
 
-	hub.SaveMessageFunc = func(msg ws.Message) error {
-		// Try to parse as JSON (new format with { content: "..." })
-		var msgObj map[string]interface{}
-		var content string
-
-		if err := json.Unmarshal(msg.Data, &msgObj); err == nil && msgObj["content"] != nil {
-			// New JSON format
-			if c, ok := msgObj["content"].(string); ok {
-				content = c
-			}
-		} else {
-			// Fallback: try old "Sender: content" format (won't work well but keep for compat)
-			parts := strings.SplitN(string(msg.Data), ": ", 2)
-			if len(parts) != 2 {
-				return nil
-			}
-			content = parts[1]
-		}
-
-		// Fetch sender name
-		var senderName string
-		err := db.DB.QueryRow(`SELECT username FROM users WHERE id=$1`, msg.UserID).Scan(&senderName)
-		if err != nil || senderName == "" {
-			_ = db.DB.QueryRow(`SELECT email FROM users WHERE id=$1`, msg.UserID).Scan(&senderName)
-		}
-		if senderName == "" {
-			senderName = "User"
-		}
-
-		return db.SaveMessage(db.DB, models.Message{
-			GroupID:   msg.GroupID,
-			SenderID:  msg.UserID,
-			SenderName: senderName,
-			Content:   content,
-		})
-	}
-
 	handler := c.Handler(r)
 
-	fmt.Println("Server started on :8080")
-	http.ListenAndServe(":8080", handler)
+	fmt.Printf("Server started on %s (%s)\n", cfg.ListenAddr, cfg.Env)
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, handler))
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"studybuddy/internal/config"
+	"studybuddy/internal/db"
+)
+
+const migrateUsage = `usage: studybuddy migrate <command>
+
+commands:
+  up           apply all pending migrations
+  down [n]     roll back the last n applied migrations (default 1)
+  status       list migrations and whether they have been applied
+  list         list the migration files embedded in this binary
+  schema       print the schema produced by all embedded migrations`
+
+// runMigrateCommand handles `studybuddy migrate ...` and returns the exit code
+func runMigrateCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		db.Connect(cfg.DB)
+		count, err := db.MigrateUp()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate up: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%d migration(s) applied\n", count)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				fmt.Fprintln(os.Stderr, "migrate down: n must be a positive integer")
+				return 2
+			}
+			steps = n
+		}
+		db.Connect(cfg.DB)
+		count, err := db.MigrateDown(steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate down: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%d migration(s) rolled back\n", count)
+
+	case "status":
+		db.Connect(cfg.DB)
+		statuses, err := db.GetMigrationStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate status: %v\n", err)
+			return 1
+		}
+		pending := 0
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			} else {
+				pending++
+			}
+			if s.Drifted {
+				state += " (checksum mismatch!)"
+			}
+			fmt.Printf("%04d  %-32s %s\n", s.Version, s.Name, state)
+		}
+		fmt.Printf("%d pending\n", pending)
+
+	case "list":
+		names, err := db.MigrationFiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate list: %v\n", err)
+			return 1
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+
+	case "schema":
+		schema, err := db.SchemaSQL()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate schema: %v\n", err)
+			return 1
+		}
+		fmt.Print(schema)
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
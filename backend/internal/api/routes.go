@@ -2,19 +2,80 @@ package api
 
 import (
 	"net/http"
+	"studybuddy/internal/auth"
 	"studybuddy/internal/handlers"
 
 	"github.com/gorilla/mux"
 )
 
+// PublicRoutes can be called without a token. Every other route on the
+// router, including ones added in main.go, requires authentication.
+var PublicRoutes = auth.Allowlist{
+	"GET /api/health",
+	"POST /api/signup",
+	"POST /api/login",
+	"POST /api/login/2fa",
+	"GET /api/auth/oidc",
+	"GET /api/auth/oidc/login",
+	"GET /api/auth/oidc/callback",
+	"POST /api/auth/oidc/exchange",
+	"POST /api/token/refresh",
+	"POST /api/email/verify",
+	"POST /api/password/forgot",
+	"POST /api/password/reset",
+	"POST /api/profile/email/confirm",
+
+	// public profiles and leaderboards
+	"GET /api/users/{id:[0-9]+}",
+	"GET /api/users/{id:[0-9]+}/stats",
+	"GET /api/users/{id:[0-9]+}/activity",
+	"GET /api/leaderboard",
+	"GET /api/ranks",
+
+	// group discovery; content handlers apply their own visibility rules
+	"GET /api/groups",
+	"GET /api/groups/search",
+	"GET /api/groups/{id:[0-9]+}",
+	"GET /api/groups/{id:[0-9]+}/members",
+	"GET /api/groups/{id:[0-9]+}/messages",
+	"GET /api/groups/{id:[0-9]+}/resources",
+	"GET /api/resources/{resourceId:[0-9]+}/download",
+
+	"/uploads/",
+}
+
 func RegisterRoutes(r *mux.Router) {
+	r.Use(auth.Middleware(PublicRoutes))
+
 	r.HandleFunc("/api/signup", handlers.Signup).Methods("POST")
 	r.HandleFunc("/api/login", handlers.Login).Methods("POST")
+	r.HandleFunc("/api/login/2fa", handlers.LoginTwoFactor).Methods("POST")
+	r.HandleFunc("/api/auth/oidc", handlers.GetSSOProvider).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/login", handlers.StartOIDCLogin).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/callback", handlers.OIDCCallback).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/exchange", handlers.ExchangeOIDCLogin).Methods("POST")
+	r.HandleFunc("/api/token/refresh", handlers.RefreshToken).Methods("POST")
+	r.HandleFunc("/api/logout", handlers.Logout).Methods("POST")
+	r.HandleFunc("/api/sessions", handlers.ListSessions).Methods("GET")
+	r.HandleFunc("/api/sessions/{id:[0-9]+}", handlers.RevokeSession).Methods("DELETE")
+	r.HandleFunc("/api/user/signins", handlers.GetSigninHistory).Methods("GET")
+
+	// Two-factor authentication
+	r.HandleFunc("/api/2fa", handlers.GetTwoFactorStatus).Methods("GET")
+	r.HandleFunc("/api/2fa/setup", handlers.SetupTwoFactor).Methods("POST")
+	r.HandleFunc("/api/2fa/enable", handlers.EnableTwoFactor).Methods("POST")
+	r.HandleFunc("/api/2fa/disable", handlers.DisableTwoFactor).Methods("POST")
+	r.HandleFunc("/api/2fa/recovery-codes", handlers.RegenerateRecoveryCodes).Methods("POST")
+	r.HandleFunc("/api/email/verify", handlers.VerifyEmail).Methods("POST")
+	r.HandleFunc("/api/email/verify/resend", handlers.ResendVerification).Methods("POST")
+	r.HandleFunc("/api/password/forgot", handlers.ForgotPassword).Methods("POST")
+	r.HandleFunc("/api/password/reset", handlers.ResetPassword).Methods("POST")
 
 	// Profile routes
 	r.HandleFunc("/api/profile", handlers.GetProfile).Methods("GET")
 	r.HandleFunc("/api/profile", handlers.UpdateProfile).Methods("PUT")
 	r.HandleFunc("/api/profile/email", handlers.ChangeEmail).Methods("PUT")
+	r.HandleFunc("/api/profile/email/confirm", handlers.ConfirmEmailChange).Methods("POST")
 	r.HandleFunc("/api/profile/phone", handlers.ChangePhone).Methods("PUT")
 	r.HandleFunc("/api/profile/password", handlers.ChangePassword).Methods("PUT")
 	r.HandleFunc("/api/profile/delete", handlers.DeleteAccount).Methods("DELETE")
@@ -29,12 +90,27 @@ func RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/api/groups/{id:[0-9]+}/messages", handlers.GetGroupMessages).Methods("GET")
 	r.HandleFunc("/api/groups/{id:[0-9]+}/messages", handlers.PostGroupMessage).Methods("POST")
 	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/upload", handlers.UploadMessage).Methods("POST")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}", handlers.EditMessage).Methods("PUT")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}", handlers.DeleteMessage).Methods("DELETE")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}/revisions", handlers.GetMessageRevisions).Methods("GET")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}/thread", handlers.GetMessageThread).Methods("GET")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}/seen", handlers.GetMessageSeenBy).Methods("GET")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}/vote", handlers.VotePoll).Methods("POST")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}/pin", handlers.PinMessage).Methods("POST")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/messages/{messageId:[0-9]+}/pin", handlers.UnpinMessage).Methods("DELETE")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/pins", handlers.GetGroupPins).Methods("GET")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/mute", handlers.MuteGroup).Methods("PUT")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/read", handlers.MarkGroupRead).Methods("POST")
+	r.HandleFunc("/api/groups/{id:[0-9]+}/search", handlers.SearchGroup).Methods("GET")
+	r.HandleFunc("/api/search", handlers.Search).Methods("GET")
+	r.HandleFunc("/ws/{groupID:[0-9]+}", handlers.WsHandler).Methods("GET")
 	r.HandleFunc("/ws", handlers.WsHandler).Methods("GET")
 
 	// Groups
 	r.HandleFunc("/api/groups", handlers.ListGroups).Methods("GET")
 	r.HandleFunc("/api/groups/search", handlers.SearchGroups).Methods("GET")
 	r.HandleFunc("/api/user/groups", handlers.GetMyGroups).Methods("GET")
+	r.HandleFunc("/api/user/mentions", handlers.GetMyMentions).Methods("GET")
 	r.HandleFunc("/api/groups", handlers.CreateGroup).Methods("POST")
 	r.HandleFunc("/api/groups/{id:[0-9]+}", handlers.GetGroup).Methods("GET")
 	r.HandleFunc("/api/groups/{id:[0-9]+}", handlers.UpdateGroup).Methods("PUT")
@@ -87,6 +163,20 @@ func RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/api/resources/{resourceId:[0-9]+}/download", handlers.DownloadGroupResource).Methods("GET")
 	r.HandleFunc("/api/resources/{resourceId:[0-9]+}", handlers.DeleteGroupResource).Methods("DELETE")
 
+	// Platform administration, admins only
+	adminRouter := r.PathPrefix("/api/admin").Subrouter()
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return auth.RequireScope(auth.ScopeAdmin, h) }
+	adminRouter.HandleFunc("/users", admin(handlers.AdminListUsers)).Methods("GET")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}", admin(handlers.AdminGetUser)).Methods("GET")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/role", admin(handlers.AdminSetRole)).Methods("PUT")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/suspend", admin(handlers.AdminSuspendUser)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/ban", admin(handlers.AdminBanUser)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/unban", admin(handlers.AdminUnbanUser)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/points/remove", admin(handlers.AdminRemovePoints)).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/signins", admin(handlers.AdminGetUserSignins)).Methods("GET")
+	adminRouter.HandleFunc("/groups/{id:[0-9]+}", admin(handlers.AdminDeleteGroup)).Methods("DELETE")
+	adminRouter.HandleFunc("/audit", admin(handlers.AdminGetAuditLog)).Methods("GET")
+
 	r.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("API running 🚀"))
 	}).Methods("GET")
@@ -0,0 +1,241 @@
+// Package auth issues and verifies access tokens and carries the
+// authenticated principal through request contexts.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"studybuddy/internal/config"
+	"studybuddy/internal/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoToken      = errors.New("no auth token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrRevoked      = errors.New("session revoked")
+)
+
+const (
+	// ScopeUser is granted to every regular login
+	ScopeUser = "user"
+	// ScopeAdmin is granted to platform admins and unlocks /api/admin
+	ScopeAdmin = "admin"
+)
+
+// ScopesForRole maps a platform role to the scopes of its access tokens
+func ScopesForRole(role string) []string {
+	if role == db.RoleAdmin {
+		return []string{ScopeUser, ScopeAdmin}
+	}
+	return []string{ScopeUser}
+}
+
+// ChallengeTTL is how long a user has to enter their 2FA code after the
+// password was accepted
+const ChallengeTTL = 5 * time.Minute
+
+// typChallenge marks tokens that only prove the password step of a login
+const typChallenge = "2fa_challenge"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID    int
+	SessionID int
+	Scopes    []string
+}
+
+// HasScope reports whether the principal was granted scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	jwtSecret = []byte(config.Default().Auth.JWTSecret)
+	tokenTTL  = config.Default().Auth.TokenTTL
+)
+
+// Configure injects the signing secret and token lifetime
+func Configure(cfg *config.Config) {
+	jwtSecret = []byte(cfg.Auth.JWTSecret)
+	tokenTTL = cfg.Auth.TokenTTL
+}
+
+// TokenTTL is how long issued access tokens stay valid
+func TokenTTL() time.Duration {
+	return tokenTTL
+}
+
+// IssueAccessToken signs an access token bound to a session
+func IssueAccessToken(userID int, sessionID int, scopes ...string) (string, error) {
+	if len(scopes) == 0 {
+		scopes = []string{ScopeUser}
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"sid":     sessionID,
+		"scopes":  scopes,
+		"iat":     now.Unix(),
+		"exp":     now.Add(tokenTTL).Unix(),
+	})
+	return token.SignedString(jwtSecret)
+}
+
+// IssueChallengeToken signs a short-lived token for a login that still needs
+// its second factor. It is not accepted as an access token.
+func IssueChallengeToken(userID int) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"typ":     typChallenge,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ChallengeTTL).Unix(),
+	})
+	return token.SignedString(jwtSecret)
+}
+
+// ParseChallengeToken returns the user a challenge token was issued to
+func ParseChallengeToken(tokenStr string) (int, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return 0, err
+	}
+	if typ, _ := claims["typ"].(string); typ != typChallenge {
+		return 0, ErrInvalidToken
+	}
+	return intClaim(claims, "user_id")
+}
+
+func parseClaims(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// ParseToken validates an access token and checks that its session has not
+// been revoked
+func ParseToken(tokenStr string) (*Principal, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["typ"]; ok {
+		// challenge and other special-purpose tokens
+		return nil, ErrInvalidToken
+	}
+
+	uid, err := intClaim(claims, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	sid, err := intClaim(claims, "sid")
+	if err != nil {
+		// tokens issued before sessions existed cannot be revoked
+		return nil, ErrInvalidToken
+	}
+
+	p := &Principal{UserID: uid, SessionID: sid}
+	if raw, ok := claims["scopes"].([]interface{}); ok {
+		for _, s := range raw {
+			if str, ok := s.(string); ok {
+				p.Scopes = append(p.Scopes, str)
+			}
+		}
+	} else {
+		p.Scopes = []string{ScopeUser}
+	}
+
+	active, err := db.IsSessionActive(sid, uid)
+	if err != nil || !active {
+		return nil, ErrRevoked
+	}
+	return p, nil
+}
+
+func intClaim(claims jwt.MapClaims, name string) (int, error) {
+	// jwt stores numbers as float64 when decoded from JSON
+	switch v := claims[name].(type) {
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("%w: missing %s", ErrInvalidToken, name)
+	}
+}
+
+// TokenFromRequest returns the bearer token of a request. Browsers cannot set
+// headers on WebSocket handshakes, so upgrades may pass ?token= instead.
+func TokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if parts := strings.SplitN(h, " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+			return strings.TrimSpace(parts[1])
+		}
+		return ""
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// Authenticate resolves the principal of a request
+func Authenticate(r *http.Request) (*Principal, error) {
+	tokenStr := TokenFromRequest(r)
+	if tokenStr == "" {
+		return nil, ErrNoToken
+	}
+	return ParseToken(tokenStr)
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored by the middleware, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// UserID returns the authenticated user id, or 0 for anonymous requests
+func UserID(ctx context.Context) int {
+	if p, ok := FromContext(ctx); ok {
+		return p.UserID
+	}
+	return 0
+}
+
+// SessionID returns the authenticated session id, or 0 for anonymous requests
+func SessionID(ctx context.Context) int {
+	if p, ok := FromContext(ctx); ok {
+		return p.SessionID
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Allowlist names the routes that may be called without a token, as
+// "METHOD /path/template" or just "/path/template" for any method. Public
+// routes still see the principal when a valid token is sent.
+type Allowlist []string
+
+func (a Allowlist) allows(method, template string) bool {
+	for _, entry := range a {
+		m, path, hasMethod := strings.Cut(entry, " ")
+		if !hasMethod {
+			path = m
+		} else if !strings.EqualFold(m, method) {
+			continue
+		}
+		if path == template {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware authenticates every matched route. Routes outside the allowlist
+// get a 401 without a valid token; the principal is stored in the request
+// context for handlers to read with FromContext or UserID.
+func Middleware(public Allowlist) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// CORS preflights never carry credentials
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			isPublic := false
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					isPublic = public.allows(r.Method, tpl)
+				}
+			}
+
+			p, err := Authenticate(r)
+			if err != nil {
+				if isPublic {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// RequireScope rejects principals that were not granted scope
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := FromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.HasScope(scope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAllowlist(t *testing.T) {
+	public := Allowlist{
+		"GET /api/groups/{id:[0-9]+}",
+		"post /api/login",
+		"/api/health",
+	}
+	tests := []struct {
+		method, template string
+		want             bool
+	}{
+		{"GET", "/api/groups/{id:[0-9]+}", true},
+		{"DELETE", "/api/groups/{id:[0-9]+}", false},
+		// templates match exactly, not as prefixes or patterns
+		{"GET", "/api/groups/{id}", false},
+		{"GET", "/api/groups/{id:[0-9]+}/messages", false},
+		{"POST", "/api/login", true}, // methods ignore case
+		{"GET", "/api/login", false},
+		{"GET", "/api/health", true}, // no method: any method
+		{"POST", "/api/health", true},
+		{"GET", "/api/profile", false},
+	}
+	for _, tt := range tests {
+		if got := public.allows(tt.method, tt.template); got != tt.want {
+			t.Errorf("allows(%s %s) = %v, want %v", tt.method, tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if _, signedIn := FromContext(r.Context()); signedIn {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+	r.HandleFunc("/api/groups/{id:[0-9]+}", ok).Methods("GET", "DELETE", "OPTIONS")
+	r.HandleFunc("/api/profile", ok).Methods("GET")
+	r.Use(Middleware(Allowlist{"GET /api/groups/{id:[0-9]+}"}))
+
+	challenge, err := IssueChallengeToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, path, token string
+		want                      int
+	}{
+		{"public route", "GET", "/api/groups/5", "", http.StatusOK},
+		{"public route, bad token", "GET", "/api/groups/5", "garbage", http.StatusOK},
+		{"other method", "DELETE", "/api/groups/5", "", http.StatusUnauthorized},
+		{"preflight", "OPTIONS", "/api/groups/5", "", http.StatusOK},
+		{"private route", "GET", "/api/profile", "", http.StatusUnauthorized},
+		{"bad token", "GET", "/api/profile", "garbage", http.StatusUnauthorized},
+		{"challenge token", "GET", "/api/profile", challenge, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	h := RequireScope(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name string
+		p    *Principal
+		want int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"user", &Principal{UserID: 1, Scopes: []string{ScopeUser}}, http.StatusForbidden},
+		{"admin", &Principal{UserID: 1, Scopes: []string{ScopeUser, ScopeAdmin}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/admin/users", nil)
+		if tt.p != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), tt.p))
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
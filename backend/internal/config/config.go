@@ -0,0 +1,427 @@
+// Package config loads the server settings from environment variables and an
+// optional TOML file. Environment variables always win over the file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultJWTSecret is only accepted when running in development mode
+const DefaultJWTSecret = "supersecretkey"
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// Mail drivers
+const (
+	MailLog  = "log"  // print messages to stdout
+	MailFile = "file" // write one .eml file per message to Mail.Dir
+	MailSMTP = "smtp"
+)
+
+// Realtime brokers
+const (
+	BrokerMemory   = "memory"   // single replica
+	BrokerPostgres = "postgres" // LISTEN/NOTIFY, for several replicas
+)
+
+type Config struct {
+	Env        string
+	ListenAddr string
+	AppURL     string // public frontend URL used for links in emails
+
+	DB       DBConfig
+	Auth     AuthConfig
+	CORS     CORSConfig
+	Uploads  UploadConfig
+	Mail     MailConfig
+	OIDC     OIDCConfig
+	Realtime RealtimeConfig
+}
+
+type DBConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+}
+
+type AuthConfig struct {
+	JWTSecret  string
+	TokenTTL   time.Duration // access token lifetime
+	RefreshTTL time.Duration // session lifetime, extended on every refresh
+
+	// Brute-force protection. After MaxLoginFailures failed logins in a row
+	// an account is locked for LoginLockout, doubling with every further
+	// failure; an IP gets the same lockout after MaxIPLoginFailures failures
+	// within LoginFailureWindow.
+	MaxLoginFailures   int
+	MaxIPLoginFailures int
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers name the client. Requests from anywhere else are
+	// attributed to their own address.
+	TrustedProxies []netip.Prefix
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string
+}
+
+type UploadConfig struct {
+	MaxMessageBytes      int64 // chat attachments
+	MaxResourceBytes     int64 // group resources tab
+	MaxProfilePhotoBytes int64
+}
+
+type MailConfig struct {
+	Driver       string
+	From         string
+	Dir          string // output directory for the file driver
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+}
+
+// OIDCConfig enables single sign-on through an OpenID Connect provider. SSO
+// is off while Issuer is empty.
+type OIDCConfig struct {
+	Name            string // shown on the login button
+	Issuer          string
+	ClientID        string
+	ClientSecret    string // optional for public clients, PKCE is always used
+	RedirectURL     string // our callback, e.g. https://api.example/api/auth/oidc/callback
+	Scopes          []string
+	UniversityClaim string // claim copied into users.university, if set
+}
+
+// Enabled reports whether SSO is configured
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// RealtimeConfig picks how websocket events reach the other replicas
+type RealtimeConfig struct {
+	Broker string
+}
+
+// IsDev reports whether the server runs in development mode
+func (c *Config) IsDev() bool {
+	return c.Env == EnvDevelopment
+}
+
+// ConnString builds the lib/pq connection URL
+func (d DBConfig) ConnString() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+		d.User, d.Password, d.Host, d.Port, d.Name, d.SSLMode)
+}
+
+// Default returns the settings used when nothing is configured. The server
+// runs in production mode unless APP_ENV says otherwise, so a deployment that
+// forgets it still gets the production checks of Validate.
+func Default() *Config {
+	return &Config{
+		Env:        EnvProduction,
+		ListenAddr: ":8080",
+		AppURL:     "http://localhost:5173",
+		DB: DBConfig{
+			Host:    "localhost",
+			Port:    "5432",
+			SSLMode: "disable",
+		},
+		Auth: AuthConfig{
+			JWTSecret:  DefaultJWTSecret,
+			TokenTTL:   24 * time.Hour,
+			RefreshTTL: 30 * 24 * time.Hour,
+
+			MaxLoginFailures:   5,
+			MaxIPLoginFailures: 20,
+			LoginFailureWindow: 15 * time.Minute,
+			LoginLockout:       time.Minute,
+		},
+		CORS: CORSConfig{
+			// both common vite dev ports (5173 and 5174) and the Docker frontend
+			AllowedOrigins: []string{"http://localhost:5173", "http://localhost:5174", "http://localhost:3000", "http://frontend:3000"},
+		},
+		Uploads: UploadConfig{
+			MaxMessageBytes:      200 << 20,
+			MaxResourceBytes:     100 << 20,
+			MaxProfilePhotoBytes: 10 << 20,
+		},
+		Mail: MailConfig{
+			Driver:   MailLog,
+			From:     "StudyBuddy <no-reply@studybuddy.local>",
+			Dir:      "./mail",
+			SMTPPort: "587",
+		},
+		OIDC: OIDCConfig{
+			Name:   "Campus login",
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Realtime: RealtimeConfig{
+			Broker: BrokerMemory,
+		},
+	}
+}
+
+// setting maps one option to its file key and environment variable
+type setting struct {
+	key string // "section.name" in the config file
+	env string
+	set func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"env", "APP_ENV", func(c *Config, v string) error { c.Env = strings.ToLower(v); return nil }},
+	{"listen_addr", "LISTEN_ADDR", func(c *Config, v string) error { c.ListenAddr = v; return nil }},
+	{"app_url", "APP_URL", func(c *Config, v string) error { c.AppURL = strings.TrimRight(v, "/"); return nil }},
+
+	{"db.host", "DB_HOST", func(c *Config, v string) error { c.DB.Host = v; return nil }},
+	{"db.port", "DB_PORT", func(c *Config, v string) error { c.DB.Port = v; return nil }},
+	{"db.user", "DB_USER", func(c *Config, v string) error { c.DB.User = v; return nil }},
+	{"db.password", "DB_PASSWORD", func(c *Config, v string) error { c.DB.Password = v; return nil }},
+	{"db.name", "DB_NAME", func(c *Config, v string) error { c.DB.Name = v; return nil }},
+	{"db.sslmode", "DB_SSLMODE", func(c *Config, v string) error { c.DB.SSLMode = v; return nil }},
+
+	{"auth.jwt_secret", "JWT_SECRET", func(c *Config, v string) error { c.Auth.JWTSecret = v; return nil }},
+	{"auth.token_ttl", "JWT_TTL", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Auth.TokenTTL = d
+		return err
+	}},
+	{"auth.refresh_ttl", "JWT_REFRESH_TTL", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Auth.RefreshTTL = d
+		return err
+	}},
+	{"auth.max_login_failures", "LOGIN_MAX_FAILURES", integer(func(c *Config) *int { return &c.Auth.MaxLoginFailures })},
+	{"auth.max_ip_login_failures", "LOGIN_MAX_IP_FAILURES", integer(func(c *Config) *int { return &c.Auth.MaxIPLoginFailures })},
+	{"auth.login_failure_window", "LOGIN_FAILURE_WINDOW", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Auth.LoginFailureWindow = d
+		return err
+	}},
+	{"auth.login_lockout", "LOGIN_LOCKOUT", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Auth.LoginLockout = d
+		return err
+	}},
+	{"auth.trusted_proxies", "TRUSTED_PROXIES", func(c *Config, v string) error {
+		prefixes, err := parsePrefixes(splitList(v))
+		c.Auth.TrustedProxies = prefixes
+		return err
+	}},
+
+	{"cors.allowed_origins", "CORS_ALLOWED_ORIGINS", func(c *Config, v string) error {
+		c.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+
+	{"uploads.max_message_mb", "MAX_MESSAGE_UPLOAD_MB", megabytes(func(c *Config) *int64 { return &c.Uploads.MaxMessageBytes })},
+	{"uploads.max_resource_mb", "MAX_RESOURCE_UPLOAD_MB", megabytes(func(c *Config) *int64 { return &c.Uploads.MaxResourceBytes })},
+	{"uploads.max_profile_photo_mb", "MAX_PROFILE_PHOTO_MB", megabytes(func(c *Config) *int64 { return &c.Uploads.MaxProfilePhotoBytes })},
+
+	{"mail.driver", "MAIL_DRIVER", func(c *Config, v string) error { c.Mail.Driver = strings.ToLower(v); return nil }},
+	{"mail.from", "MAIL_FROM", func(c *Config, v string) error { c.Mail.From = v; return nil }},
+	{"mail.dir", "MAIL_DIR", func(c *Config, v string) error { c.Mail.Dir = v; return nil }},
+	{"mail.smtp_host", "SMTP_HOST", func(c *Config, v string) error { c.Mail.SMTPHost = v; return nil }},
+	{"mail.smtp_port", "SMTP_PORT", func(c *Config, v string) error { c.Mail.SMTPPort = v; return nil }},
+	{"mail.smtp_user", "SMTP_USER", func(c *Config, v string) error { c.Mail.SMTPUser = v; return nil }},
+	{"mail.smtp_password", "SMTP_PASSWORD", func(c *Config, v string) error { c.Mail.SMTPPassword = v; return nil }},
+
+	{"oidc.name", "OIDC_PROVIDER_NAME", func(c *Config, v string) error { c.OIDC.Name = v; return nil }},
+	{"oidc.issuer", "OIDC_ISSUER", func(c *Config, v string) error { c.OIDC.Issuer = strings.TrimRight(v, "/"); return nil }},
+	{"oidc.client_id", "OIDC_CLIENT_ID", func(c *Config, v string) error { c.OIDC.ClientID = v; return nil }},
+	{"oidc.client_secret", "OIDC_CLIENT_SECRET", func(c *Config, v string) error { c.OIDC.ClientSecret = v; return nil }},
+	{"oidc.redirect_url", "OIDC_REDIRECT_URL", func(c *Config, v string) error { c.OIDC.RedirectURL = v; return nil }},
+	{"oidc.scopes", "OIDC_SCOPES", func(c *Config, v string) error { c.OIDC.Scopes = splitList(v); return nil }},
+	{"oidc.university_claim", "OIDC_UNIVERSITY_CLAIM", func(c *Config, v string) error { c.OIDC.UniversityClaim = v; return nil }},
+
+	{"realtime.broker", "REALTIME_BROKER", func(c *Config, v string) error { c.Realtime.Broker = strings.ToLower(v); return nil }},
+}
+
+func integer(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func megabytes(field func(c *Config) *int64) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = mb << 20
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// parsePrefixes reads IP addresses and CIDR ranges; a bare address is a
+// range of one
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+// IsTrustedProxy reports whether addr is one of the TrustedProxies
+func (a AuthConfig) IsTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range a.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load builds the config from defaults, then the file named by CONFIG_FILE
+// (if set), then environment variables, and validates the result.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		known := make(map[string]bool, len(settings))
+		for _, s := range settings {
+			known[s.key] = true
+			if v, ok := values[s.key]; ok {
+				if err := s.set(cfg, v); err != nil {
+					return nil, fmt.Errorf("config file %s: %s: %w", path, s.key, err)
+				}
+			}
+		}
+		for key := range values {
+			if !known[key] {
+				return nil, fmt.Errorf("config file %s: unknown key %q", path, key)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks required fields and refuses unsafe production settings
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		problems = append(problems, fmt.Sprintf("env must be %q or %q, got %q", EnvDevelopment, EnvProduction, c.Env))
+	}
+	if c.ListenAddr == "" {
+		problems = append(problems, "listen address is required")
+	}
+	if c.DB.Host == "" || c.DB.Port == "" || c.DB.User == "" || c.DB.Name == "" {
+		problems = append(problems, "DB_HOST, DB_PORT, DB_USER and DB_NAME are required")
+	}
+	if c.Auth.JWTSecret == "" {
+		problems = append(problems, "JWT_SECRET is required")
+	} else if c.Auth.JWTSecret == DefaultJWTSecret && !c.IsDev() {
+		problems = append(problems, "JWT_SECRET must be changed from the default outside development")
+	}
+	if c.Auth.TokenTTL <= 0 || c.Auth.RefreshTTL <= 0 {
+		problems = append(problems, "token TTLs must be positive")
+	} else if c.Auth.RefreshTTL < c.Auth.TokenTTL {
+		problems = append(problems, "refresh TTL must not be shorter than the access token TTL")
+	}
+	if c.Auth.MaxLoginFailures <= 0 || c.Auth.MaxIPLoginFailures <= 0 {
+		problems = append(problems, "login failure limits must be positive")
+	}
+	if c.Auth.LoginFailureWindow <= 0 || c.Auth.LoginLockout <= 0 {
+		problems = append(problems, "login failure window and lockout must be positive")
+	}
+	if c.Uploads.MaxMessageBytes <= 0 || c.Uploads.MaxResourceBytes <= 0 || c.Uploads.MaxProfilePhotoBytes <= 0 {
+		problems = append(problems, "upload limits must be positive")
+	}
+
+	switch c.Mail.Driver {
+	case MailLog:
+	case MailFile:
+		if c.Mail.Dir == "" {
+			problems = append(problems, "MAIL_DIR is required for the file mail driver")
+		}
+	case MailSMTP:
+		if c.Mail.SMTPHost == "" || c.Mail.SMTPPort == "" {
+			problems = append(problems, "SMTP_HOST and SMTP_PORT are required for the smtp mail driver")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("mail driver must be %q, %q or %q, got %q", MailLog, MailFile, MailSMTP, c.Mail.Driver))
+	}
+	if c.Mail.From == "" {
+		problems = append(problems, "MAIL_FROM is required")
+	}
+	if c.OIDC.Enabled() {
+		if c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "" {
+			problems = append(problems, "OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+		}
+		if !strings.HasPrefix(c.OIDC.Issuer, "https://") && !c.IsDev() {
+			problems = append(problems, "OIDC_ISSUER must use https outside development")
+		}
+	}
+	if c.Realtime.Broker != BrokerMemory && c.Realtime.Broker != BrokerPostgres {
+		problems = append(problems, fmt.Sprintf("realtime broker must be %q or %q, got %q", BrokerMemory, BrokerPostgres, c.Realtime.Broker))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// valid returns a config that passes Validate in production
+func valid() *Config {
+	c := Default()
+	c.DB.User = "studybuddy"
+	c.DB.Name = "studybuddy"
+	c.Auth.JWTSecret = "a-real-secret"
+	return c
+}
+
+func TestDefaultIsProduction(t *testing.T) {
+	c := Default()
+	if c.Env != EnvProduction || c.IsDev() {
+		t.Fatalf("default env is %q", c.Env)
+	}
+	// the production checks apply to an unconfigured server
+	c.DB.User, c.DB.Name = "studybuddy", "studybuddy"
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "JWT_SECRET must be changed") {
+		t.Fatalf("got %v, want the default secret rejected", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+[db]
+user = "file-user"
+name = "studybuddy"
+[auth]
+jwt_secret = "from-file"
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("APP_ENV", "")
+	t.Setenv("DB_USER", "env-user")
+	t.Setenv("REALTIME_BROKER", "Postgres")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Env != EnvProduction {
+		t.Errorf("env = %q, want production", c.Env)
+	}
+	// environment variables win over the file
+	if c.DB.User != "env-user" || c.Auth.JWTSecret != "from-file" || c.Realtime.Broker != BrokerPostgres {
+		t.Errorf("unexpected config %+v", c)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, "[db]\nhostname = \"x\"\n"))
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), `unknown key "db.hostname"`) {
+		t.Fatalf("got %v, want an unknown key error", err)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("DB_USER", "studybuddy")
+	t.Setenv("DB_NAME", "studybuddy")
+	t.Setenv("JWT_SECRET", "a-real-secret")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.10, ::1")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3":           true,
+		"::ffff:10.1.2.3":    true,
+		"192.168.1.10":       true,
+		"192.168.1.11":       false,
+		"::1":                true,
+		"203.0.113.7":        false,
+		"2001:db8::1":        false,
+		"::ffff:203.0.113.7": false,
+	} {
+		if got := c.Auth.IsTrustedProxy(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("IsTrustedProxy(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") {
+		t.Fatalf("got %v, want an invalid TRUSTED_PROXIES error", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string // "" for valid
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"development allows the default secret", func(c *Config) {
+			c.Env = EnvDevelopment
+			c.Auth.JWTSecret = DefaultJWTSecret
+		}, ""},
+		{"default secret", func(c *Config) { c.Auth.JWTSecret = DefaultJWTSecret }, "JWT_SECRET must be changed"},
+		{"no secret", func(c *Config) { c.Auth.JWTSecret = "" }, "JWT_SECRET is required"},
+		{"unknown env", func(c *Config) { c.Env = "staging" }, "env must be"},
+		{"missing db", func(c *Config) { c.DB.Name = "" }, "DB_NAME are required"},
+		{"refresh shorter than token", func(c *Config) { c.Auth.RefreshTTL = c.Auth.TokenTTL / 2 }, "refresh TTL"},
+		{"no lockout", func(c *Config) { c.Auth.LoginLockout = 0 }, "lockout must be positive"},
+		{"smtp without host", func(c *Config) { c.Mail.Driver = MailSMTP }, "SMTP_HOST"},
+		{"unknown mail driver", func(c *Config) { c.Mail.Driver = "pigeon" }, "mail driver"},
+		{"oidc without client", func(c *Config) { c.OIDC.Issuer = "https://idp.example" }, "OIDC_CLIENT_ID"},
+		{"http oidc issuer", func(c *Config) {
+			c.OIDC = OIDCConfig{Issuer: "http://idp.example", ClientID: "id", RedirectURL: "https://app/cb"}
+		}, "must use https"},
+		{"http oidc issuer in development", func(c *Config) {
+			c.Env = EnvDevelopment
+			c.OIDC = OIDCConfig{Issuer: "http://localhost:9000", ClientID: "id", RedirectURL: "http://localhost/cb"}
+		}, ""},
+		{"unknown broker", func(c *Config) { c.Realtime.Broker = "redis" }, "realtime broker"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readFile parses the small TOML subset we need: [section] tables holding
+// string, integer, boolean and string-array values. Keys come back as
+// "section.key"; arrays are joined with commas.
+//
+//	env = "production"
+//	[db]
+//	host = "postgres"
+//	[cors]
+//	allowed_origins = ["https://studybuddy.example"]
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed table header", lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// stripComment drops a trailing # comment that is not inside a string
+func stripComment(line string) string {
+	var quote rune
+	for i, ch := range line {
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be on a single line")
+		}
+		var items []string
+		for _, part := range strings.Split(raw[1:len(raw)-1], ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			item, err := parseValue(part)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	default:
+		if _, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, 64); err != nil {
+			return "", fmt.Errorf("unsupported value %s", raw)
+		}
+		return strings.ReplaceAll(raw, "_", ""), nil
+	}
+}
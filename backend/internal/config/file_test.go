@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	path := writeConfig(t, `
+# a comment
+env = "production"   # trailing comment
+listen_addr = ':9090'
+
+[db]
+password = "p#ss \"quoted\""
+port = 5_432
+
+[cors]
+allowed_origins = ["https://a.example", 'https://b.example', ]
+[mail]
+tls = true
+`)
+	values, err := readFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"env":                  "production",
+		"listen_addr":          ":9090",
+		"db.password":          `p#ss "quoted"`,
+		"db.port":              "5432",
+		"cors.allowed_origins": "https://a.example,https://b.example",
+		"mail.tls":             "true",
+	}
+	if len(values) != len(want) {
+		t.Errorf("got %d values, want %d: %v", len(values), len(want), values)
+	}
+	for key, v := range want {
+		if values[key] != v {
+			t.Errorf("%s = %q, want %q", key, values[key], v)
+		}
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	tests := map[string]string{
+		"[db":                     "malformed table header",
+		"just a key":              "expected key = value",
+		"key =":                   "missing value",
+		"key = [\"a\",":           "single line",
+		"key = 'open":             "unterminated string",
+		"key = 1.5":               "unsupported value",
+		"key = \"open":            "line 1",
+		"ok = 1\n\nbad = nowhere": "line 3",
+	}
+	for content, wantErr := range tests {
+		_, err := readFile(writeConfig(t, content))
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%q: got error %v, want %q", content, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Platform roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// AdminUser is a user as seen by platform admins
+type AdminUser struct {
+	ID               int        `json:"id"`
+	Username         string     `json:"username"`
+	Email            string     `json:"email"`
+	PlatformRole     string     `json:"platform_role"`
+	EmailVerified    bool       `json:"email_verified"`
+	TotalPoints      int        `json:"total_points"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastSeen         *time.Time `json:"last_seen,omitempty"`
+	SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`
+	BannedAt         *time.Time `json:"banned_at,omitempty"`
+	ModerationReason *string    `json:"moderation_reason,omitempty"`
+}
+
+// AuditEntry is one recorded admin action
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	AdminID    *int            `json:"admin_id"`
+	AdminName  *string         `json:"admin_name,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   *int            `json:"target_id,omitempty"`
+	Details    json.RawMessage `json:"details"`
+	IPAddress  string          `json:"ip_address"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+const adminUserColumns = `
+	u.id, u.username, u.email, u.platform_role, u.email_verified_at IS NOT NULL,
+	COALESCE(ur.total_points, 0), u.created_at, u.last_seen, u.suspended_until, u.banned_at, u.moderation_reason`
+
+func scanAdminUser(s interface{ Scan(...interface{}) error }) (*AdminUser, error) {
+	var u AdminUser
+	err := s.Scan(&u.ID, &u.Username, &u.Email, &u.PlatformRole, &u.EmailVerified,
+		&u.TotalPoints, &u.CreatedAt, &u.LastSeen, &u.SuspendedUntil, &u.BannedAt, &u.ModerationReason)
+	return &u, err
+}
+
+// SearchUsers lists users whose name or email contains query, newest first
+func SearchUsers(query string, limit int, offset int) ([]AdminUser, int, error) {
+	var total int
+	if err := DB.QueryRow(`
+		SELECT COUNT(*) FROM users
+		WHERE $1::text = '' OR username ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%'
+	`, query).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+adminUserColumns+`
+		FROM users u
+		LEFT JOIN user_ranks ur ON ur.user_id = u.id
+		WHERE $1::text = '' OR u.username ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%'
+		ORDER BY u.created_at DESC, u.id DESC
+		LIMIT $2 OFFSET $3
+	`, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := make([]AdminUser, 0)
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *u)
+	}
+	return users, total, rows.Err()
+}
+
+// GetAdminUser loads one user for the admin API
+func GetAdminUser(userID int) (*AdminUser, error) {
+	return scanAdminUser(DB.QueryRow(`
+		SELECT `+adminUserColumns+`
+		FROM users u
+		LEFT JOIN user_ranks ur ON ur.user_id = u.id
+		WHERE u.id = $1
+	`, userID))
+}
+
+// GetPlatformRole returns the platform role of a user
+func GetPlatformRole(userID int) (string, error) {
+	var role string
+	err := DB.QueryRow(`SELECT platform_role FROM users WHERE id = $1`, userID).Scan(&role)
+	return role, err
+}
+
+// SetPlatformRole changes a user's platform role
+func SetPlatformRole(userID int, role string) error {
+	_, err := DB.Exec(`UPDATE users SET platform_role = $2 WHERE id = $1`, userID, role)
+	return err
+}
+
+// SuspendUser blocks logins until the given time
+func SuspendUser(userID int, until time.Time, reason string) error {
+	_, err := DB.Exec(`UPDATE users SET suspended_until = $2, moderation_reason = $3 WHERE id = $1`,
+		userID, until.UTC(), reason)
+	return err
+}
+
+// BanUser blocks logins indefinitely
+func BanUser(userID int, reason string) error {
+	_, err := DB.Exec(`UPDATE users SET banned_at = NOW(), moderation_reason = $2 WHERE id = $1`, userID, reason)
+	return err
+}
+
+// LiftUserRestrictions clears bans and suspensions
+func LiftUserRestrictions(userID int) error {
+	_, err := DB.Exec(`UPDATE users SET banned_at = NULL, suspended_until = NULL, moderation_reason = NULL WHERE id = $1`, userID)
+	return err
+}
+
+// GetAccountRestriction returns whether a user may not sign in, and until
+// when for suspensions (nil for bans)
+func GetAccountRestriction(userID int) (blocked bool, until *time.Time, err error) {
+	var banned bool
+	var suspendedUntil sql.NullTime
+	err = DB.QueryRow(`
+		SELECT banned_at IS NOT NULL, CASE WHEN suspended_until > NOW() THEN suspended_until END
+		FROM users WHERE id = $1
+	`, userID).Scan(&banned, &suspendedUntil)
+	if err != nil {
+		return false, nil, err
+	}
+	if banned {
+		return true, nil, nil
+	}
+	if suspendedUntil.Valid {
+		return true, &suspendedUntil.Time, nil
+	}
+	return false, nil, nil
+}
+
+// RecordAdminAction appends to the audit log. adminID 0 means the command line.
+func RecordAdminAction(adminID int, action string, targetType string, targetID int, details map[string]interface{}, ip string) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	var admin, target sql.NullInt64
+	if adminID != 0 {
+		admin = sql.NullInt64{Int64: int64(adminID), Valid: true}
+	}
+	if targetID != 0 {
+		target = sql.NullInt64{Int64: int64(targetID), Valid: true}
+	}
+	_, err = DB.Exec(`
+		INSERT INTO admin_audit_log (admin_id, action, target_type, target_id, details, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, admin, action, targetType, target, detailsJSON, ip)
+	return err
+}
+
+// GetAuditLog lists admin actions, newest first. targetType and targetID
+// filter when set.
+func GetAuditLog(targetType string, targetID int, limit int, offset int) ([]AuditEntry, error) {
+	rows, err := DB.Query(`
+		SELECT a.id, a.admin_id, u.username, a.action, a.target_type, a.target_id, a.details, COALESCE(a.ip_address, ''), a.created_at
+		FROM admin_audit_log a
+		LEFT JOIN users u ON u.id = a.admin_id
+		WHERE ($1::text = '' OR a.target_type = $1) AND ($2 = 0 OR a.target_id = $2)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $3 OFFSET $4
+	`, targetType, targetID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.AdminID, &e.AdminName, &e.Action, &e.TargetType, &e.TargetID, &details, &e.IPAddress, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Details = json.RawMessage(details)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
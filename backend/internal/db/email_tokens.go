@@ -0,0 +1,84 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Email token purposes
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	TokenChangeEmail   = "change_email"
+)
+
+// ErrEmailTokenInvalid is returned for unknown, expired or already used tokens
+var ErrEmailTokenInvalid = errors.New("email token invalid")
+
+// EmailToken is a consumed single-use token
+type EmailToken struct {
+	UserID   int
+	NewEmail string // only set for change_email
+}
+
+// CreateEmailToken issues a token for purpose and returns it in raw form for
+// the email link. Older unused tokens with the same purpose stop working.
+func CreateEmailToken(userID int, purpose string, newEmail string, ttl time.Duration) (string, error) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE email_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose); err != nil {
+		return "", err
+	}
+
+	var email sql.NullString
+	if newEmail != "" {
+		email = sql.NullString{String: newEmail, Valid: true}
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO email_tokens (user_id, purpose, token_hash, new_email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, purpose, hash, email, time.Now().UTC().Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// ConsumeEmailToken marks a token as used and returns what it was issued for.
+// A token can only be consumed once.
+func ConsumeEmailToken(token string, purpose string) (*EmailToken, error) {
+	var t EmailToken
+	var newEmail sql.NullString
+	err := DB.QueryRow(`
+		UPDATE email_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, new_email
+	`, HashToken(token), purpose).Scan(&t.UserID, &newEmail)
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.NewEmail = newEmail.String
+	return &t, nil
+}
+
+// MarkEmailVerified records that the user proved ownership of their address
+func MarkEmailVerified(userID int) error {
+	_, err := DB.Exec(`UPDATE users SET email_verified_at = NOW() WHERE id = $1 AND email_verified_at IS NULL`, userID)
+	return err
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GroupEventRetention is how many of its latest events a group keeps for
+// replay
+const GroupEventRetention = 500
+
+// GroupEvent is a stored realtime event of a group
+type GroupEvent struct {
+	Seq   int64
+	Event []byte // the encoded envelope, without its seq
+}
+
+// AppendGroupEventTx numbers an event with the group's next sequence number
+// and stores it in tx, together with the change it describes. The group's
+// row stays locked until tx ends, so transactions of one group commit in seq
+// order.
+func AppendGroupEventTx(tx *sql.Tx, groupID int, event []byte) (int64, error) {
+	var seq int64
+	err := tx.QueryRow(`
+		WITH next AS (UPDATE groups SET event_seq = event_seq + 1 WHERE id = $1 RETURNING event_seq)
+		INSERT INTO group_events (group_id, seq, event, created_at)
+		SELECT $1, event_seq, $2, $3 FROM next
+		RETURNING seq
+	`, groupID, string(event), time.Now().UTC()).Scan(&seq)
+	if err != nil {
+		return 0, err
+	}
+
+	// pruning now and then is enough
+	if seq%50 == 0 {
+		if _, err := tx.Exec(`DELETE FROM group_events WHERE group_id = $1 AND seq <= $2`,
+			groupID, seq-GroupEventRetention); err != nil {
+			return 0, fmt.Errorf("pruning events of group %d: %w", groupID, err)
+		}
+	}
+	return seq, nil
+}
+
+// WithTx runs fn in a transaction, committed when fn returns nil
+func WithTx(fn func(tx *sql.Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GroupSeq returns the sequence number of a group's latest event
+func GroupSeq(groupID int) (int64, error) {
+	var seq int64
+	err := DB.QueryRow(`SELECT event_seq FROM groups WHERE id = $1`, groupID).Scan(&seq)
+	return seq, err
+}
+
+// GetGroupEventsSince loads up to limit events of a group after seq, oldest
+// first
+func GetGroupEventsSince(groupID int, seq int64, limit int) ([]GroupEvent, error) {
+	rows, err := DB.Query(`
+		SELECT seq, event FROM group_events
+		WHERE group_id = $1 AND seq > $2
+		ORDER BY seq LIMIT $3
+	`, groupID, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []GroupEvent
+	for rows.Next() {
+		var e GroupEvent
+		var event string
+		if err := rows.Scan(&e.Seq, &event); err != nil {
+			return nil, err
+		}
+		e.Event = []byte(event)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
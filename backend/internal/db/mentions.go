@@ -0,0 +1,143 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"studybuddy/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Mention kinds, from the most to the least direct
+const (
+	MentionUser   = "user"
+	MentionAdmins = "admins"
+	MentionAll    = "all"
+)
+
+// Mention is a member mentioned in a message
+type Mention struct {
+	UserID int
+	Kind   string
+}
+
+// MentionEntry is a message the user was mentioned in
+type MentionEntry struct {
+	MessageID int64
+	GroupID   int
+	GroupName string
+	Kind      string
+}
+
+// GroupMembersByUsername looks up members of a group by username, ignoring
+// case
+func GroupMembersByUsername(groupID int, usernames []string) ([]int, error) {
+	lower := make([]string, len(usernames))
+	for i, name := range usernames {
+		lower[i] = strings.ToLower(name)
+	}
+	return queryIDs(`SELECT gm.user_id FROM group_members gm JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = $1 AND LOWER(u.username) = ANY($2)`, groupID, pq.Array(lower))
+}
+
+// GroupMemberIDs lists the members of a group, or only its admins
+func GroupMemberIDs(groupID int, adminsOnly bool) ([]int, error) {
+	return queryIDs(`SELECT user_id FROM group_members WHERE group_id = $1 AND ($2 = false OR role = 'admin')`,
+		groupID, adminsOnly)
+}
+
+func queryIDs(query string, args ...interface{}) ([]int, error) {
+	return scanIDs(DB.Query(query, args...))
+}
+
+// scanIDs reads a single column of ids
+func scanIDs(rows *sql.Rows, err error) ([]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SyncMentions makes mentions the members mentioned in a message, and
+// returns the ones that were not mentioned before (to notify them)
+func SyncMentions(messageID int64, mentions []Mention) (added []int, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(mentions))
+	for i, mention := range mentions {
+		ids[i] = mention.UserID
+	}
+	if _, err := tx.Exec(`DELETE FROM message_mentions WHERE message_id = $1 AND user_id != ALL($2)`,
+		messageID, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	for _, mention := range mentions {
+		var inserted bool
+		err := tx.QueryRow(`
+			INSERT INTO message_mentions (message_id, user_id, kind) VALUES ($1, $2, $3)
+			ON CONFLICT (message_id, user_id) DO UPDATE SET kind = EXCLUDED.kind
+			RETURNING xmax = 0
+		`, messageID, mention.UserID, mention.Kind).Scan(&inserted)
+		if err != nil {
+			return nil, err
+		}
+		if inserted {
+			added = append(added, mention.UserID)
+		}
+	}
+	return added, tx.Commit()
+}
+
+// GetMentions lists up to limit messages the user was mentioned in, newest
+// first, starting below the message id before (0 for the latest). Deleted
+// messages and groups the user left are skipped.
+func GetMentions(userID int, before int64, limit int) ([]MentionEntry, error) {
+	rows, err := DB.Query(`
+		SELECT mn.message_id, m.group_id, g.name, mn.kind
+		FROM message_mentions mn
+		JOIN messages m ON m.id = mn.message_id
+		JOIN groups g ON g.id = m.group_id
+		JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = mn.user_id
+		WHERE mn.user_id = $1 AND m.deleted_at IS NULL AND ($2 = 0 OR mn.message_id < $2)
+		ORDER BY mn.message_id DESC
+		LIMIT $3
+	`, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]MentionEntry, 0)
+	for rows.Next() {
+		var e MentionEntry
+		if err := rows.Scan(&e.MessageID, &e.GroupID, &e.GroupName, &e.Kind); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetMessagesByID loads listed messages by id, in no particular order
+func GetMessagesByID(ids []int64) ([]models.Message, error) {
+	rows, err := DB.Query(listedMessages+` WHERE m.id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	return scanListedMessages(rows)
+}
@@ -2,36 +2,326 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"studybuddy/internal/models"
 )
 
-func SaveMessage(db *sql.DB, msg models.Message) error {
-	_, err := db.Exec(
-		"INSERT INTO messages (group_id, sender_id, sender_name, content, created_at, message_type) VALUES ($1, $2, $3, $4, $5, $6)",
-		msg.GroupID, msg.SenderID, msg.SenderName, msg.Content, time.Now().UTC(), "text",
-	)
-	return err
+// ErrMessageDeleted is returned when changing a message that was deleted
+var ErrMessageDeleted = errors.New("message deleted")
+
+const messageColumns = `id, group_id, sender_id, COALESCE(sender_name, ''), content,
+	COALESCE(message_type, 'text'), created_at, edited_at, deleted_at, reply_to_id, payload`
+
+func scanMessage(s interface{ Scan(...interface{}) error }) (*models.Message, error) {
+	var m models.Message
+	var payload []byte
+	err := s.Scan(&m.ID, &m.GroupID, &m.SenderID, &m.SenderName, &m.Content,
+		&m.MessageType, &m.CreatedAt, &m.EditedAt, &m.DeletedAt, &m.ReplyToID, &payload)
+	m.Payload = payload
+	return &m, err
 }
 
-func GetMessages(db *sql.DB, groupID string) ([]models.Message, error) {
-	rows, err := db.Query(
-		"SELECT id, group_id, sender_id, sender_name, content, created_at FROM messages WHERE group_id = $1 ORDER BY created_at ASC",
-		groupID,
-	)
-	if err != nil {
-		return nil, err
-	}
+// listedMessages selects messages (as m) together with their reply count,
+// whether they are pinned and the message they reply to (as p)
+const listedMessages = `SELECT m.id, m.group_id, m.sender_id, COALESCE(m.sender_name, ''), m.content,
+	COALESCE(m.message_type, 'text'), m.created_at, m.edited_at, m.deleted_at, m.reply_to_id, m.payload,
+	(SELECT COUNT(*) FROM messages r WHERE r.reply_to_id = m.id AND r.deleted_at IS NULL),
+	EXISTS(SELECT 1 FROM message_pins pn WHERE pn.message_id = m.id),
+	p.sender_id, COALESCE(p.sender_name, ''), COALESCE(p.content, ''), COALESCE(p.message_type, 'text'),
+	COALESCE(p.deleted_at IS NOT NULL, false)
+	FROM messages m LEFT JOIN messages p ON p.id = m.reply_to_id`
+
+func scanListedMessages(rows *sql.Rows) ([]models.Message, error) {
 	defer rows.Close()
 
 	var msgs []models.Message
 	for rows.Next() {
 		var m models.Message
-		if err := rows.Scan(&m.ID, &m.GroupID, &m.SenderID, &m.SenderName, &m.Content, &m.CreatedAt); err != nil {
+		var quoted models.QuotedMessage
+		var quotedSender sql.NullInt64
+		var payload []byte
+		err := rows.Scan(&m.ID, &m.GroupID, &m.SenderID, &m.SenderName, &m.Content,
+			&m.MessageType, &m.CreatedAt, &m.EditedAt, &m.DeletedAt, &m.ReplyToID, &payload,
+			&m.ReplyCount, &m.Pinned,
+			&quotedSender, &quoted.SenderName, &quoted.Content, &quoted.MessageType, &quoted.Deleted)
+		if err != nil {
 			return nil, err
 		}
+		m.Payload = payload
+		// the parent is gone when it was removed for good
+		if m.ReplyToID != nil && quotedSender.Valid {
+			quoted.ID = *m.ReplyToID
+			quoted.SenderID = int(quotedSender.Int64)
+			m.ReplyTo = &quoted
+		}
 		msgs = append(msgs, m)
 	}
-	return msgs, nil
+	return msgs, rows.Err()
+}
+
+// SaveMessage stores a chat message in a group: its group, sender, content,
+// type and, if set, payload and the message it replies to. The sender name is
+// copied onto the message so it survives renames and deleted accounts.
+//
+// clientKey, when set, makes the send idempotent: if the sender already sent
+// a message with that key to the group, that message is returned and created
+// is false.
+//
+// inTx, if set, runs in the transaction that inserts a new message, e.g. to
+// store its realtime event; an error from it rolls the message back.
+func SaveMessage(msg models.Message, clientKey string, inTx func(tx *sql.Tx, m *models.Message) error) (m *models.Message, created bool, err error) {
+	m = &msg
+	err = DB.QueryRow(`SELECT COALESCE(NULLIF(username, ''), email, '') FROM users WHERE id=$1`, m.SenderID).Scan(&m.SenderName)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, err
+	}
+	if m.SenderName == "" {
+		m.SenderName = "User"
+	}
+
+	// ALWAYS use UTC
+	m.CreatedAt = time.Now().UTC()
+	var payload interface{}
+	if len(m.Payload) > 0 {
+		payload = string(m.Payload)
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO messages (group_id, sender_id, sender_name, content, created_at, message_type, reply_to_id, payload, client_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))
+		ON CONFLICT (group_id, sender_id, client_key) WHERE client_key IS NOT NULL DO NOTHING
+		RETURNING id`,
+		m.GroupID, m.SenderID, m.SenderName, m.Content, m.CreatedAt, m.MessageType, m.ReplyToID, payload, clientKey,
+	).Scan(&m.ID)
+	if err == sql.ErrNoRows && clientKey != "" {
+		tx.Rollback()
+		m, err = GetMessageByClientKey(m.GroupID, m.SenderID, clientKey)
+		return m, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if inTx != nil {
+		if err := inTx(tx, m); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return m, true, nil
+}
+
+// GetMessageByClientKey finds the message a sender sent to a group with an
+// idempotency key
+func GetMessageByClientKey(groupID int, senderID int, clientKey string) (*models.Message, error) {
+	return scanMessage(DB.QueryRow("SELECT "+messageColumns+` FROM messages
+		WHERE group_id = $1 AND sender_id = $2 AND client_key = $3`, groupID, senderID, clientKey))
+}
+
+// MessageCursor is a position in a group's history. Messages are ordered by
+// created_at, then id.
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// MessageCursorAt returns the position of a message of the group
+func MessageCursorAt(groupID int, messageID int64) (MessageCursor, error) {
+	c := MessageCursor{ID: messageID}
+	err := DB.QueryRow(`SELECT created_at FROM messages WHERE id = $1 AND group_id = $2`,
+		messageID, groupID).Scan(&c.CreatedAt)
+	return c, err
+}
+
+// MessageCursorFrom returns the position right before the first message sent
+// at or after t
+func MessageCursorFrom(t time.Time) MessageCursor {
+	return MessageCursor{CreatedAt: t.UTC(), ID: 0}
+}
+
+// HasMessagesBefore reports whether the group has messages before the cursor
+func HasMessagesBefore(groupID int, c MessageCursor) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM messages
+		WHERE group_id = $1 AND created_at <= $2 AND (created_at, id) < ($2, $3))`,
+		groupID, c.CreatedAt, c.ID).Scan(&exists)
+	return exists, err
+}
+
+// GetMessagesBefore loads up to limit messages of a group sent before the
+// cursor, or the latest ones for a nil cursor, oldest first. more tells
+// whether older messages are left.
+func GetMessagesBefore(groupID int, c *MessageCursor, limit int) ([]models.Message, bool, error) {
+	var rows *sql.Rows
+	var err error
+	// the plain created_at bound lets idx_messages_group_created_at do the work
+	if c == nil {
+		rows, err = DB.Query(listedMessages+`
+			WHERE m.group_id = $1
+			ORDER BY m.created_at DESC, m.id DESC LIMIT $2`, groupID, limit+1)
+	} else {
+		rows, err = DB.Query(listedMessages+`
+			WHERE m.group_id = $1 AND m.created_at <= $2 AND (m.created_at, m.id) < ($2, $3)
+			ORDER BY m.created_at DESC, m.id DESC LIMIT $4`, groupID, c.CreatedAt, c.ID, limit+1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	msgs, err := scanListedMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(msgs) > limit
+	if more {
+		msgs = msgs[:limit]
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, more, nil
+}
+
+// GetMessagesAfter loads up to limit messages of a group sent after the
+// cursor, oldest first. more tells whether newer messages are left.
+func GetMessagesAfter(groupID int, c MessageCursor, limit int) ([]models.Message, bool, error) {
+	rows, err := DB.Query(listedMessages+`
+		WHERE m.group_id = $1 AND m.created_at >= $2 AND (m.created_at, m.id) > ($2, $3)
+		ORDER BY m.created_at, m.id LIMIT $4`, groupID, c.CreatedAt, c.ID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	msgs, err := scanListedMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(msgs) > limit
+	if more {
+		msgs = msgs[:limit]
+	}
+	return msgs, more, nil
+}
+
+// GetThread loads a message and every reply below it, replies to replies
+// included, in the order they were sent
+func GetThread(rootID int64) (*models.Message, []models.Message, error) {
+	rows, err := DB.Query(`
+		WITH RECURSIVE thread AS (
+			SELECT id FROM messages WHERE id = $1
+			UNION
+			SELECT r.id FROM messages r JOIN thread t ON r.reply_to_id = t.id
+		)
+		`+listedMessages+`
+		WHERE m.id IN (SELECT id FROM thread)
+		ORDER BY m.created_at, m.id`, rootID)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs, err := scanListedMessages(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var root *models.Message
+	replies := make([]models.Message, 0, len(msgs))
+	for i := range msgs {
+		if msgs[i].ID == rootID {
+			root = &msgs[i]
+		} else {
+			replies = append(replies, msgs[i])
+		}
+	}
+	if root == nil {
+		return nil, nil, sql.ErrNoRows
+	}
+	return root, replies, nil
+}
+
+// GetMessage loads one message, deleted ones included
+func GetMessage(id int64) (*models.Message, error) {
+	return scanMessage(DB.QueryRow("SELECT "+messageColumns+" FROM messages WHERE id = $1", id))
+}
+
+// EditMessage replaces the content of a message. The previous content is
+// kept in message_revisions.
+func EditMessage(id int64, editorID int, content string) (*models.Message, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var old string
+	var deletedAt sql.NullTime
+	err = tx.QueryRow(`SELECT content, deleted_at FROM messages WHERE id = $1 FOR UPDATE`, id).Scan(&old, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		return nil, ErrMessageDeleted
+	}
+
+	// ALWAYS use UTC
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`
+		INSERT INTO message_revisions (message_id, content, edited_by, edited_at)
+		VALUES ($1, $2, $3, $4)
+	`, id, old, editorID, now); err != nil {
+		return nil, err
+	}
+	m, err := scanMessage(tx.QueryRow(`
+		UPDATE messages SET content = $2, edited_at = $3 WHERE id = $1
+		RETURNING `+messageColumns, id, content, now))
+	if err != nil {
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+// DeleteMessage soft deletes a message; it stays behind as a tombstone
+func DeleteMessage(id int64, deletedBy int) (*models.Message, error) {
+	m, err := scanMessage(DB.QueryRow(`
+		UPDATE messages SET deleted_at = $3, deleted_by = $2
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+messageColumns, id, deletedBy, time.Now().UTC()))
+	if err == sql.ErrNoRows {
+		var exists bool
+		DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1)`, id).Scan(&exists)
+		if exists {
+			return nil, ErrMessageDeleted
+		}
+	}
+	return m, err
+}
+
+// GetMessageRevisions lists the earlier versions of a message, oldest first.
+// Each revision is the content as it was until edited_at.
+func GetMessageRevisions(id int64) ([]models.MessageRevision, error) {
+	rows, err := DB.Query(`
+		SELECT content, edited_by, edited_at FROM message_revisions
+		WHERE message_id = $1 ORDER BY edited_at, id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]models.MessageRevision, 0)
+	for rows.Next() {
+		var rev models.MessageRevision
+		if err := rows.Scan(&rev.Content, &rev.EditedBy, &rev.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
 }
@@ -0,0 +1,405 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the numbered migration files compiled into the binary.
+// Files are named NNNN_description.up.sql with an optional matching
+// NNNN_description.down.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key held while migrating so that
+// two replicas booting at the same time don't race each other.
+const migrationLockID = 7243100
+
+// correctedChecksums lists the checksums of migration files as they were
+// applied before a deliberate fix. They don't count as drift, and MigrateUp
+// records the current checksum instead.
+var correctedChecksums = map[int][]string{
+	// 0004 used to recreate the indexes of 0002, and its down file dropped them
+	4: {"6736679b53efa80df25dd7ac6d65d21165c7e773fa38db93a84775a3a4039a82"},
+}
+
+// Migration is a single numbered schema change
+type Migration struct {
+	Version  int
+	Name     string
+	UpSQL    string
+	DownSQL  string
+	Checksum string
+}
+
+// MigrationStatus describes a migration and whether it has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+	Drifted   bool // applied checksum no longer matches the embedded file
+}
+
+// LoadMigrations reads and orders every migration found in fsys
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+
+		var direction string
+		base := e.Name()
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+			base = strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+			base = strings.TrimSuffix(base, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", e.Name())
+		}
+
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description", e.Name())
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s has an invalid version", e.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, fmt.Errorf("migration version %d is used by both %q and %q", version, m.Name, parts[1])
+		}
+
+		if direction == "up" {
+			m.UpSQL = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.UpSQL == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// loadMigrations loads the migrations shipped with this build
+func loadMigrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrations(sub)
+}
+
+// MigrationFiles lists the migration file names embedded in this build
+func MigrationFiles() ([]string, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// SchemaSQL returns the effective schema of this build: every up migration
+// concatenated in the order they are applied
+func SchemaSQL() (string, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, m := range migrations {
+		fmt.Fprintf(&b, "-- %04d_%s (sha256 %s)\n", m.Version, m.Name, m.Checksum)
+		b.WriteString(strings.TrimRight(m.UpSQL, "\n"))
+		b.WriteString("\n\n")
+	}
+	return b.String(), nil
+}
+
+type appliedMigration struct {
+	Checksum  string
+	AppliedAt time.Time
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration lock
+func withMigrationLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(ctx, conn)
+}
+
+// drifted reports whether an applied migration was edited after it ran
+func drifted(m Migration, a appliedMigration) bool {
+	return a.Checksum != m.Checksum && !slices.Contains(correctedChecksums[m.Version], a.Checksum)
+}
+
+// checkDrift fails if any applied migration was edited after it ran
+func checkDrift(migrations []Migration, applied map[int]appliedMigration) error {
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok && drifted(m, a) {
+			return fmt.Errorf("migration %04d_%s was modified after being applied (checksum %s, recorded %s)",
+				m.Version, m.Name, m.Checksum[:12], a.Checksum[:min(12, len(a.Checksum))])
+		}
+	}
+	return nil
+}
+
+// pendingMigrations returns the migrations that have not been applied yet, in
+// order. Versions below applied ones are included, so a migration merged out
+// of order still runs.
+func pendingMigrations(migrations []Migration, applied map[int]appliedMigration) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction. It stops at the first failure and returns the number applied.
+func MigrateUp() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDrift(migrations, applied); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if a, ok := applied[m.Version]; ok && a.Checksum != m.Checksum {
+				if _, err := conn.ExecContext(ctx, `UPDATE schema_migrations SET checksum = $2 WHERE version = $1`,
+					m.Version, m.Checksum); err != nil {
+					return fmt.Errorf("record corrected checksum of %04d_%s: %w", m.Version, m.Name, err)
+				}
+			}
+		}
+
+		for _, m := range pendingMigrations(migrations, applied) {
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, m.UpSQL); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+				m.Version, m.Name, m.Checksum,
+			); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("record migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("commit migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+
+			fmt.Printf("✅ Migration applied: %04d_%s\n", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first
+func MigrateDown(steps int) (int, error) {
+	if steps <= 0 {
+		return 0, fmt.Errorf("steps must be positive")
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDrift(migrations, applied); err != nil {
+			return err
+		}
+
+		known := make(map[int]bool, len(migrations))
+		for _, m := range migrations {
+			known[m.Version] = true
+		}
+		for version := range applied {
+			if !known[version] {
+				return fmt.Errorf("applied migration %04d is missing from this build", version)
+			}
+		}
+
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.DownSQL == "" {
+				return fmt.Errorf("migration %04d_%s has no down file", m.Version, m.Name)
+			}
+
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, m.DownSQL); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("rollback %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("unrecord migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("commit rollback %04d_%s: %w", m.Version, m.Name, err)
+			}
+
+			fmt.Printf("↩️  Migration rolled back: %04d_%s\n", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// GetMigrationStatus reports every known migration and whether it has run
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			s := MigrationStatus{Migration: m}
+			if a, ok := applied[m.Version]; ok {
+				appliedAt := a.AppliedAt
+				s.Applied = true
+				s.AppliedAt = &appliedAt
+				s.Drifted = drifted(m, a)
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// runMigrations applies pending migrations on boot and refuses to continue
+// on a half-applied schema
+func runMigrations() {
+	count, err := MigrateUp()
+	if err != nil {
+		log.Fatalf("❌ Migrations failed: %v", err)
+	}
+	if count == 0 {
+		fmt.Println("✅ Schema up to date")
+	}
+}
@@ -1 +0,0 @@
-select * FROM users;
\ No newline at end of file
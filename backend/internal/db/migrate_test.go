@@ -0,0 +1,159 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"0010_later.up.sql":    file("CREATE TABLE later ();"),
+		"0002_second.up.sql":   file("CREATE TABLE second ();"),
+		"0002_second.down.sql": file("DROP TABLE second;"),
+		"0001_first.up.sql":    file("CREATE TABLE first ();"),
+		"README.md":            file("not a migration"),
+		"old/0003_x.up.sql":    file("ignored, in a directory"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// versions sort numerically, not by file name
+	var got []int
+	for _, m := range migrations {
+		got = append(got, m.Version)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 10 {
+		t.Fatalf("got versions %v, want [1 2 10]", got)
+	}
+
+	m := migrations[1]
+	if m.Name != "second" || m.UpSQL != "CREATE TABLE second ();" || m.DownSQL != "DROP TABLE second;" {
+		t.Errorf("unexpected migration %+v", m)
+	}
+	// only the up file is checksummed
+	if m.Checksum != checksum("CREATE TABLE second ();") {
+		t.Errorf("checksum %s", m.Checksum)
+	}
+	if migrations[0].DownSQL != "" {
+		t.Errorf("migration without a down file got %q", migrations[0].DownSQL)
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{"duplicate version", fstest.MapFS{
+			"0001_users.up.sql":  file("SELECT 1;"),
+			"0001_groups.up.sql": file("SELECT 1;"),
+		}, "version 1 is used by both"},
+		{"missing up file", fstest.MapFS{
+			"0001_users.up.sql":    file("SELECT 1;"),
+			"0002_groups.down.sql": file("SELECT 1;"),
+		}, "0002_groups has no up file"},
+		{"no direction", fstest.MapFS{"0001_users.sql": file("SELECT 1;")}, "must end in .up.sql or .down.sql"},
+		{"no description", fstest.MapFS{"0001.up.sql": file("SELECT 1;")}, "must be named NNNN_description"},
+		{"bad version", fstest.MapFS{"first_users.up.sql": file("SELECT 1;")}, "invalid version"},
+		{"version zero", fstest.MapFS{"0000_users.up.sql": file("SELECT 1;")}, "invalid version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadMigrations(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"0001_users.up.sql":  file("CREATE TABLE users ();"),
+		"0002_groups.up.sql": file("CREATE TABLE groups ();"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := map[int]appliedMigration{1: {Checksum: checksum("CREATE TABLE users ();")}}
+	if err := checkDrift(migrations, applied); err != nil {
+		t.Fatalf("unchanged migration reported as drift: %v", err)
+	}
+
+	applied[1] = appliedMigration{Checksum: checksum("CREATE TABLE users (id INT);")}
+	if err := checkDrift(migrations, applied); err == nil || !strings.Contains(err.Error(), "0001_users was modified") {
+		t.Fatalf("got %v, want drift of 0001", err)
+	}
+
+	// a short or garbled recorded checksum is reported, not a panic
+	applied[1] = appliedMigration{Checksum: "abc"}
+	if err := checkDrift(migrations, applied); err == nil {
+		t.Fatal("garbled checksum accepted")
+	}
+}
+
+func TestCheckDriftAcceptsCorrectedMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := make(map[int]appliedMigration)
+	for version, sums := range correctedChecksums {
+		applied[version] = appliedMigration{Checksum: sums[0]}
+	}
+	if err := checkDrift(migrations, applied); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+	applied := map[int]appliedMigration{1: {}, 3: {}}
+
+	pending := pendingMigrations(migrations, applied)
+	if len(pending) != 2 || pending[0].Version != 2 || pending[1].Version != 4 {
+		t.Fatalf("got %+v, want versions 2 and 4", pending)
+	}
+	if len(pendingMigrations(migrations, map[int]appliedMigration{1: {}, 2: {}, 3: {}, 4: {}})) != 0 {
+		t.Fatal("nothing should be pending")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createIndex := regexp.MustCompile(`(?i)CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:IF\s+NOT\s+EXISTS\s+)?(\w+)`)
+	createdBy := make(map[string]int)
+	for _, m := range migrations {
+		if m.DownSQL == "" {
+			t.Errorf("%04d_%s has no down file", m.Version, m.Name)
+		}
+		// an index created twice is dropped by the later down file
+		for _, match := range createIndex.FindAllStringSubmatch(m.UpSQL, -1) {
+			name := strings.ToLower(match[1])
+			if v, ok := createdBy[name]; ok {
+				t.Errorf("index %s is created by both %04d and %04d", name, v, m.Version)
+			}
+			createdBy[name] = m.Version
+		}
+	}
+}
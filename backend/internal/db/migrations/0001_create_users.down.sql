@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS signin_logs;
+DROP TABLE IF EXISTS users;
@@ -1,4 +1,4 @@
--- internal/db/migrate_messages.sql
+-- internal/db/migrations/0003_create_messages.up.sql
 
 CREATE TABLE IF NOT EXISTS messages (
   id SERIAL PRIMARY KEY,
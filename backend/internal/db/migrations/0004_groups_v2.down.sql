@@ -0,0 +1,2 @@
+-- The columns added by 0004 are part of the groups/group_members tables
+-- created in 0002, and so are the lookup indexes; nothing to undo.
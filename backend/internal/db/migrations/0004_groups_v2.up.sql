@@ -36,9 +36,3 @@ CREATE TABLE IF NOT EXISTS group_member_history (
     reason TEXT,
     created_at TIMESTAMP DEFAULT NOW()
 );
-
--- Create an index for faster group searches
-CREATE INDEX IF NOT EXISTS idx_groups_username ON groups(username);
-CREATE INDEX IF NOT EXISTS idx_groups_name ON groups(name);
-CREATE INDEX IF NOT EXISTS idx_group_members_group_id ON group_members(group_id);
-CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON group_members(user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS join_requests;
+ALTER TABLE groups DROP COLUMN IF EXISTS require_admin_approval;
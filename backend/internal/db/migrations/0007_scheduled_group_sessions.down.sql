@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS session_attendees;
+DROP TABLE IF EXISTS session_user_votes;
+DROP TABLE IF EXISTS session_voting_options;
+DROP TABLE IF EXISTS scheduled_group_sessions;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS rank_thresholds;
+DROP TABLE IF EXISTS daily_activity_log;
+DROP TABLE IF EXISTS message_reactions;
+DROP TABLE IF EXISTS user_ranks;
+DROP TABLE IF EXISTS user_points_ledger;
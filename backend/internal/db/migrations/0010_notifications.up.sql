@@ -1,4 +1,4 @@
--- internal/db/migrate_notifications.sql
+-- internal/db/migrations/0010_notifications.up.sql
 
 CREATE TABLE IF NOT EXISTS notifications (
     id SERIAL PRIMARY KEY,
@@ -0,0 +1,4 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS location,
+DROP COLUMN IF EXISTS university,
+DROP COLUMN IF EXISTS major;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS show_email,
+DROP COLUMN IF EXISTS show_phone,
+DROP COLUMN IF EXISTS show_location,
+DROP COLUMN IF EXISTS show_university,
+DROP COLUMN IF EXISTS show_bio;
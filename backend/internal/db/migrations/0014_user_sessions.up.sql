@@ -0,0 +1,18 @@
+-- Server-side sessions backing refresh tokens. Every access token carries the
+-- session id (sid claim) so revoking a row here logs that device out.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    signin_log_id INTEGER REFERENCES signin_logs(id) ON DELETE SET NULL,
+    refresh_token_hash TEXT NOT NULL UNIQUE,
+    previous_token_hash TEXT, -- last rotated-out token, used to detect reuse
+    ip_address TEXT,
+    user_agent TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_used_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_previous_hash ON user_sessions(previous_token_hash);
@@ -0,0 +1,10 @@
+ALTER TABLE users DROP COLUMN IF EXISTS notify_new_signin;
+
+DROP INDEX IF EXISTS idx_signin_logs_user_created;
+DROP INDEX IF EXISTS idx_signin_logs_ip_created;
+DROP INDEX IF EXISTS idx_signin_logs_email_created;
+
+DELETE FROM signin_logs WHERE success = FALSE;
+ALTER TABLE signin_logs
+DROP COLUMN IF EXISTS success,
+DROP COLUMN IF EXISTS email;
@@ -0,0 +1,15 @@
+-- Record failed logins too so they can be throttled. email is the address
+-- that was tried (lowercased), which also covers unknown accounts.
+ALTER TABLE signin_logs
+ADD COLUMN IF NOT EXISTS email TEXT,
+ADD COLUMN IF NOT EXISTS success BOOLEAN NOT NULL DEFAULT TRUE;
+
+UPDATE signin_logs s SET email = LOWER(u.email)
+FROM users u
+WHERE s.user_id = u.id AND s.email IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_signin_logs_email_created ON signin_logs(email, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_signin_logs_ip_created ON signin_logs(ip_address, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_signin_logs_user_created ON signin_logs(user_id, created_at DESC);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_new_signin BOOLEAN DEFAULT TRUE;
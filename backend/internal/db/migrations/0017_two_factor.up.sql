@@ -0,0 +1,17 @@
+-- TOTP two-factor auth. totp_secret is set when enrollment starts and only
+-- takes effect once totp_enabled_at is set. totp_last_step is the last
+-- accepted time step, so a code can't be used twice.
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+
+-- Single-use recovery codes, stored as sha256 hashes
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    used_at TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
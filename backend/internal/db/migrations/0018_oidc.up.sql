@@ -0,0 +1,28 @@
+-- External identities linked to local accounts, one per (issuer, subject)
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_login_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- In-flight SSO logins. A row is created when the browser is sent to the
+-- provider and carries the PKCE verifier and nonce; after the callback it
+-- holds a one-time code the frontend exchanges for tokens.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    id SERIAL PRIMARY KEY,
+    state_hash TEXT NOT NULL UNIQUE,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    state_used_at TIMESTAMP,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    login_code_hash TEXT UNIQUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
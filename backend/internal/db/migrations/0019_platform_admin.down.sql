@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS admin_audit_log;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_platform_role_check;
+ALTER TABLE users
+DROP COLUMN IF EXISTS moderation_reason,
+DROP COLUMN IF EXISTS banned_at,
+DROP COLUMN IF EXISTS suspended_until,
+DROP COLUMN IF EXISTS platform_role;
@@ -0,0 +1,25 @@
+-- Platform-wide roles, separate from the per-group role in group_members
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS platform_role TEXT NOT NULL DEFAULT 'user',
+ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMP,
+ADD COLUMN IF NOT EXISTS banned_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS moderation_reason TEXT;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_platform_role_check;
+ALTER TABLE users ADD CONSTRAINT users_platform_role_check CHECK (platform_role IN ('user', 'admin'));
+
+-- Every action taken through the admin API. admin_id is NULL for actions run
+-- from the command line.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id SERIAL PRIMARY KEY,
+    admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id INTEGER,
+    details JSONB NOT NULL DEFAULT '{}',
+    ip_address TEXT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created ON admin_audit_log(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_target ON admin_audit_log(target_type, target_id);
@@ -0,0 +1,11 @@
+-- Realtime events too large for a NOTIFY payload (8000 bytes). The postgres
+-- broker stores them here and only sends the id; rows are pruned after a
+-- few minutes.
+CREATE TABLE IF NOT EXISTS realtime_events (
+    id BIGSERIAL PRIMARY KEY,
+    channel TEXT NOT NULL,
+    event TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_realtime_events_created ON realtime_events(created_at);
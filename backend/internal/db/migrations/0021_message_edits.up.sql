@@ -0,0 +1,17 @@
+-- Edited and soft-deleted messages. Deleted messages stay as tombstones so
+-- the conversation keeps its shape.
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
+-- Previous versions of edited messages, one row per edit
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    edited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    edited_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions(message_id, edited_at);
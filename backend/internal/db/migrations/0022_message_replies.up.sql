@@ -0,0 +1,6 @@
+-- Replies quote the message they answer; a message and its replies form a
+-- thread
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS reply_to_id INTEGER REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(reply_to_id) WHERE reply_to_id IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_group_resources_search;
+ALTER TABLE group_resources DROP COLUMN IF EXISTS search_vector;
+
+DROP INDEX IF EXISTS idx_messages_search;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
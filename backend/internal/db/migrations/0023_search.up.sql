@@ -0,0 +1,19 @@
+-- Full-text search over chat messages and shared files. Separators in file
+-- names are turned into spaces so "eigen_values.pdf" matches "eigen".
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    to_tsvector('english', CASE
+        WHEN message_type = 'file'
+        THEN regexp_replace(content, '[._-]+', ' ', 'g')
+        ELSE content
+    END)
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search_vector);
+
+ALTER TABLE group_resources
+ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    to_tsvector('english', regexp_replace(filename, '[._-]+', ' ', 'g'))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_group_resources_search ON group_resources USING GIN (search_vector);
@@ -0,0 +1,11 @@
+-- How far each member has read a group's chat. Members without a row count
+-- messages sent since they joined as unread.
+CREATE TABLE IF NOT EXISTS group_read_state (
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_read_message_id INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_read_state_user ON group_read_state(user_id);
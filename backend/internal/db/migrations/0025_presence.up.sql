@@ -0,0 +1,11 @@
+-- Replicas a user has open websocket connections on. Each replica refreshes
+-- its rows; rows of a replica that stopped (crash, redeploy) expire, so its
+-- users don't look online forever. is_online follows the fresh rows.
+CREATE TABLE IF NOT EXISTS presence_connections (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    instance_id TEXT NOT NULL,
+    last_heartbeat TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, instance_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_presence_connections_heartbeat ON presence_connections(last_heartbeat);
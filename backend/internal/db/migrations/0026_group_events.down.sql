@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS group_events;
+
+ALTER TABLE groups DROP COLUMN IF EXISTS event_seq;
@@ -0,0 +1,11 @@
+-- Replayable realtime events of each group, numbered per group so
+-- reconnecting clients can ask for everything after the last one they saw
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS event_seq BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS group_events (
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    event TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, seq)
+);
@@ -0,0 +1,6 @@
+-- Client supplied idempotency keys: a retried send finds the message it
+-- already created instead of inserting it again
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_key VARCHAR(100);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_key
+ON messages(group_id, sender_id, client_key) WHERE client_key IS NOT NULL;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS poll_votes;
+
+UPDATE messages SET content = (payload || '{"type": "file"}')::text
+WHERE message_type = 'file' AND payload IS NOT NULL;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS payload;
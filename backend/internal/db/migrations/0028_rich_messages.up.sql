@@ -0,0 +1,16 @@
+-- Structured message types keep their data in a typed payload; content holds
+-- a plain text rendering for search, previews and older clients
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS payload JSONB;
+
+-- file messages kept their metadata as JSON in content; it moves to the
+-- payload and content becomes the file name
+UPDATE messages SET payload = content::jsonb - 'type', content = COALESCE(content::jsonb->>'filename', '')
+WHERE message_type = 'file' AND payload IS NULL AND content LIKE '{%';
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    option_index SMALLINT NOT NULL,
+    voted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, option_index)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE group_members DROP COLUMN IF EXISTS muted;
+
+DROP TABLE IF EXISTS message_pins;
@@ -0,0 +1,12 @@
+-- Messages pinned by group admins
+CREATE TABLE IF NOT EXISTS message_pins (
+    message_id INTEGER PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    pinned_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    pinned_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_pins_group ON message_pins(group_id, pinned_at DESC);
+
+-- Muted groups send no mention or reply notifications; announcements still do
+ALTER TABLE group_members ADD COLUMN IF NOT EXISTS muted BOOLEAN NOT NULL DEFAULT false;
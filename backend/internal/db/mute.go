@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// SetGroupMuted mutes or unmutes a group for a member; false means they are
+// not a member
+func SetGroupMuted(groupID int, userID int, muted bool) (bool, error) {
+	res, err := DB.Exec(`UPDATE group_members SET muted = $3 WHERE group_id = $1 AND user_id = $2`,
+		groupID, userID, muted)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// IsGroupMuted reports whether a member muted a group; users who are not
+// members have not
+func IsGroupMuted(groupID int, userID int) (bool, error) {
+	var muted bool
+	err := DB.QueryRow(`SELECT muted FROM group_members WHERE group_id = $1 AND user_id = $2`,
+		groupID, userID).Scan(&muted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return muted, err
+}
+
+// MutedMembers returns the members who muted a group
+func MutedMembers(groupID int) (map[int]bool, error) {
+	ids, err := queryIDs(`SELECT user_id FROM group_members WHERE group_id = $1 AND muted`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	muted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		muted[id] = true
+	}
+	return muted, nil
+}
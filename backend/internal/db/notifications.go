@@ -7,7 +7,7 @@ import (
 type Notification struct {
 	ID              int       `json:"id"`
 	UserID          int       `json:"user_id"`
-	Type            string    `json:"type"` // new_message, new_session, session_reminder
+	Type            string    `json:"type"` // mention, message_reply, new_session, session_reminder
 	Title           string    `json:"title"`
 	Message         string    `json:"message"`
 	RelatedGroupID  *int      `json:"related_group_id,omitempty"`
@@ -17,13 +17,33 @@ type Notification struct {
 	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
 }
 
+// NotificationCreated, when set, is called with every stored notification;
+// main.go uses it to push notifications to open websocket connections
+var NotificationCreated func(n Notification)
+
 // CreateNotification creates a new notification
 func CreateNotification(userID int, notificationType string, title string, message string, relatedGroupID *int, relatedSessionID *int, expiresAt *time.Time) error {
-	_, err := DB.Exec(`
+	n := Notification{
+		UserID:           userID,
+		Type:             notificationType,
+		Title:            title,
+		Message:          message,
+		RelatedGroupID:   relatedGroupID,
+		RelatedSessionID: relatedSessionID,
+		ExpiresAt:        expiresAt,
+	}
+	err := DB.QueryRow(`
 		INSERT INTO notifications (user_id, type, title, message, related_group_id, related_session_id, expires_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, userID, notificationType, title, message, relatedGroupID, relatedSessionID, expiresAt)
-	return err
+		RETURNING id, created_at
+	`, userID, notificationType, title, message, relatedGroupID, relatedSessionID, expiresAt).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if NotificationCreated != nil {
+		NotificationCreated(n)
+	}
+	return nil
 }
 
 // GetUserNotifications gets recent unread notifications for a user
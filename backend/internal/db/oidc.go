@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrOIDCLoginInvalid is returned for unknown, expired or reused SSO state
+// and login codes
+var ErrOIDCLoginInvalid = errors.New("sso login invalid")
+
+// ssoPassword is stored for accounts created through SSO. It is not a valid
+// bcrypt hash, so password login fails until the user sets a password with
+// the reset flow.
+const ssoPassword = "!sso"
+
+// CreateOIDCLogin stores the state of a login that is being sent to the
+// provider
+func CreateOIDCLogin(state string, nonce string, codeVerifier string, ttl time.Duration) error {
+	// logins that were abandoned at the provider
+	if _, err := DB.Exec(`DELETE FROM oidc_logins WHERE expires_at < NOW() - INTERVAL '1 day'`); err != nil {
+		return err
+	}
+	_, err := DB.Exec(`
+		INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, HashToken(state), nonce, codeVerifier, time.Now().UTC().Add(ttl))
+	return err
+}
+
+// ConsumeOIDCState resolves the state returned by the provider. Each state
+// works once.
+func ConsumeOIDCState(state string) (loginID int, nonce string, codeVerifier string, err error) {
+	err = DB.QueryRow(`
+		UPDATE oidc_logins SET state_used_at = NOW()
+		WHERE state_hash = $1 AND state_used_at IS NULL AND expires_at > NOW()
+		RETURNING id, nonce, code_verifier
+	`, HashToken(state)).Scan(&loginID, &nonce, &codeVerifier)
+	if err == sql.ErrNoRows {
+		err = ErrOIDCLoginInvalid
+	}
+	return loginID, nonce, codeVerifier, err
+}
+
+// SetOIDCLoginCode attaches the signed-in user and the one-time code handed
+// to the frontend
+func SetOIDCLoginCode(loginID int, userID int, code string, ttl time.Duration) error {
+	_, err := DB.Exec(`
+		UPDATE oidc_logins SET user_id = $2, login_code_hash = $3, expires_at = $4
+		WHERE id = $1
+	`, loginID, userID, HashToken(code), time.Now().UTC().Add(ttl))
+	return err
+}
+
+// ConsumeOIDCLoginCode returns the user a login code was issued for
+func ConsumeOIDCLoginCode(code string) (int, error) {
+	var userID int
+	err := DB.QueryRow(`
+		DELETE FROM oidc_logins
+		WHERE login_code_hash = $1 AND user_id IS NOT NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, HashToken(code)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrOIDCLoginInvalid
+	}
+	return userID, err
+}
+
+// FindIdentityUser returns the user linked to an external identity, or 0
+func FindIdentityUser(issuer string, subject string) (int, error) {
+	var userID int
+	err := DB.QueryRow(`
+		UPDATE user_identities SET last_login_at = NOW()
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id
+	`, issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userID, err
+}
+
+// LinkIdentity connects an external identity to an existing user
+func LinkIdentity(userID int, issuer string, subject string, email string) error {
+	_, err := DB.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+	`, userID, issuer, subject, email)
+	return err
+}
+
+// CreateSSOUser creates an account for an external identity and links it
+func CreateSSOUser(username string, email string, university string, emailVerified bool, issuer string, subject string) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users (username, email, password, university, email_verified_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id
+	`, username, email, ssoPassword, university, verifiedAt).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+	`, userID, issuer, subject, email); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// UsernameTaken reports whether a username is in use
+func UsernameTaken(username string) (bool, error) {
+	var taken bool
+	err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`, username).Scan(&taken)
+	return taken, err
+}
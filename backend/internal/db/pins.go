@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPinLimit is returned when pinning in a group that has as many pinned
+// messages as it may have
+var ErrPinLimit = errors.New("pin limit reached")
+
+// Pin is a pinned message of a group
+type Pin struct {
+	MessageID    int64
+	PinnedBy     int
+	PinnedByName string
+	PinnedAt     time.Time
+}
+
+// PinMessage pins a message of a group. Only pins of messages that were not
+// deleted count towards limit. Pinning a pinned message changes nothing;
+// pinned tells whether it was pinned now.
+func PinMessage(groupID int, messageID int64, pinnedBy int, limit int) (pin *Pin, pinned bool, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// serializes pinning per group so the limit holds
+	if _, err := tx.Exec(`SELECT id FROM groups WHERE id = $1 FOR UPDATE`, groupID); err != nil {
+		return nil, false, err
+	}
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM message_pins WHERE message_id = $1)`, messageID).Scan(&exists); err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		var count int
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FROM message_pins pn JOIN messages m ON m.id = pn.message_id
+			WHERE pn.group_id = $1 AND m.deleted_at IS NULL
+		`, groupID).Scan(&count)
+		if err != nil {
+			return nil, false, err
+		}
+		if count >= limit {
+			return nil, false, ErrPinLimit
+		}
+		// ALWAYS use UTC
+		if _, err := tx.Exec(`
+			INSERT INTO message_pins (message_id, group_id, pinned_by, pinned_at)
+			VALUES ($1, $2, $3, $4)
+		`, messageID, groupID, pinnedBy, time.Now().UTC()); err != nil {
+			return nil, false, err
+		}
+	}
+
+	pin = &Pin{MessageID: messageID}
+	err = tx.QueryRow(`
+		SELECT COALESCE(pn.pinned_by, 0), COALESCE(NULLIF(u.username, ''), u.email, ''), pn.pinned_at
+		FROM message_pins pn LEFT JOIN users u ON u.id = pn.pinned_by
+		WHERE pn.message_id = $1
+	`, messageID).Scan(&pin.PinnedBy, &pin.PinnedByName, &pin.PinnedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	return pin, !exists, tx.Commit()
+}
+
+// UnpinMessage unpins a message and reports whether it was pinned
+func UnpinMessage(messageID int64) (bool, error) {
+	res, err := DB.Exec(`DELETE FROM message_pins WHERE message_id = $1`, messageID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetPins lists the pinned messages of a group that were not deleted, most
+// recently pinned first
+func GetPins(groupID int) ([]Pin, error) {
+	rows, err := DB.Query(`
+		SELECT pn.message_id, COALESCE(pn.pinned_by, 0), COALESCE(NULLIF(u.username, ''), u.email, ''), pn.pinned_at
+		FROM message_pins pn
+		JOIN messages m ON m.id = pn.message_id
+		LEFT JOIN users u ON u.id = pn.pinned_by
+		WHERE pn.group_id = $1 AND m.deleted_at IS NULL
+		ORDER BY pn.pinned_at DESC, pn.message_id DESC
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := make([]Pin, 0)
+	for rows.Next() {
+		var p Pin
+		if err := rows.Scan(&p.MessageID, &p.PinnedBy, &p.PinnedByName, &p.PinnedAt); err != nil {
+			return nil, err
+		}
+		pins = append(pins, p)
+	}
+	return pins, rows.Err()
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"time"
+
+	"studybuddy/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// SetPollVotes replaces a user's votes on a poll with the given options. No
+// options retracts the vote.
+func SetPollVotes(messageID int64, userID int, options []int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM poll_votes WHERE message_id = $1 AND user_id = $2`, messageID, userID); err != nil {
+		return err
+	}
+	// ALWAYS use UTC
+	now := time.Now().UTC()
+	for _, option := range options {
+		if _, err := tx.Exec(`
+			INSERT INTO poll_votes (message_id, user_id, option_index, voted_at)
+			VALUES ($1, $2, $3, $4)
+		`, messageID, userID, option, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetPollResults tallies the votes on polls, keyed by message id. Counts has
+// one entry per option (optionCounts gives how many each poll has); MyVotes
+// are the viewer's.
+func GetPollResults(optionCounts map[int64]int, viewerID int) (map[int64]*models.PollResults, error) {
+	results := make(map[int64]*models.PollResults, len(optionCounts))
+	ids := make([]int64, 0, len(optionCounts))
+	for id, n := range optionCounts {
+		results[id] = &models.PollResults{Counts: make([]int, n), MyVotes: []int{}}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return results, nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT message_id, option_index, COUNT(*), COALESCE(BOOL_OR(user_id = $2), false)
+		FROM poll_votes WHERE message_id = ANY($1)
+		GROUP BY message_id, option_index
+	`, pq.Array(ids), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var option, count int
+		var mine bool
+		if err := rows.Scan(&id, &option, &count, &mine); err != nil {
+			return nil, err
+		}
+		res := results[id]
+		// options are never removed, but be safe with stray votes
+		if option < 0 || option >= len(res.Counts) {
+			continue
+		}
+		res.Counts[option] = count
+		if mine {
+			res.MyVotes = append(res.MyVotes, option)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	voters, err := DB.Query(`
+		SELECT message_id, COUNT(DISTINCT user_id) FROM poll_votes
+		WHERE message_id = ANY($1) GROUP BY message_id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer voters.Close()
+	for voters.Next() {
+		var id int64
+		var n int
+		if err := voters.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		results[id].Voters = n
+	}
+	return results, voters.Err()
+}
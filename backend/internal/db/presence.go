@@ -0,0 +1,135 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// InstanceID tells this replica's rows in presence_connections apart from
+// those of other replicas. It is new on every start, so rows left behind by
+// an earlier run only expire.
+var InstanceID = newInstanceID()
+
+func newInstanceID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Presence is a user's presence with their privacy flags
+type Presence struct {
+	UserID       int
+	Online       bool
+	WasOnline    bool // before the update
+	LastSeen     *time.Time
+	ShowOnline   bool
+	ShowLastSeen bool
+}
+
+// UpdatePresence records this replica gaining a user's first connection
+// (online) or losing their last one. The user stays online while any replica
+// has a fresh connection row (see ExpirePresence); last_seen is set when a
+// replica loses them.
+func UpdatePresence(userID int, online bool, ttl time.Duration) (*Presence, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p := Presence{UserID: userID}
+	// the row lock serializes updates of one user across replicas
+	err = tx.QueryRow(`SELECT COALESCE(is_online, false) FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&p.WasOnline)
+	if err != nil {
+		return nil, err
+	}
+
+	// ALWAYS use UTC
+	now := time.Now().UTC()
+	if online {
+		_, err = tx.Exec(`
+			INSERT INTO presence_connections (user_id, instance_id, last_heartbeat) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, instance_id) DO UPDATE SET last_heartbeat = EXCLUDED.last_heartbeat
+		`, userID, InstanceID, now)
+	} else {
+		_, err = tx.Exec(`DELETE FROM presence_connections WHERE user_id = $1 AND instance_id = $2`, userID, InstanceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		UPDATE users SET
+			is_online = EXISTS(SELECT 1 FROM presence_connections WHERE user_id = $1 AND last_heartbeat > $3),
+			last_seen = CASE WHEN $2 THEN last_seen ELSE $4 END
+		WHERE id = $1
+		RETURNING is_online, last_seen, COALESCE(show_online, true), COALESCE(show_last_seen, true)
+	`, userID, online, now.Add(-ttl), now).Scan(&p.Online, &p.LastSeen, &p.ShowOnline, &p.ShowLastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return &p, tx.Commit()
+}
+
+// HeartbeatPresence refreshes this replica's connection rows
+func HeartbeatPresence() error {
+	_, err := DB.Exec(`UPDATE presence_connections SET last_heartbeat = $2 WHERE instance_id = $1`,
+		InstanceID, time.Now().UTC())
+	return err
+}
+
+// ExpirePresence drops connection rows that were not refreshed within ttl,
+// left behind by replicas that stopped, and returns the users who went
+// offline because of it
+func ExpirePresence(ttl time.Duration) ([]Presence, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// ALWAYS use UTC
+	now := time.Now().UTC()
+	userIDs, err := scanIDs(tx.Query(`DELETE FROM presence_connections WHERE last_heartbeat <= $1 RETURNING user_id`,
+		now.Add(-ttl)))
+	if err != nil || len(userIDs) == 0 {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		UPDATE users SET is_online = false, last_seen = $2
+		WHERE id = ANY($1) AND is_online
+			AND NOT EXISTS(SELECT 1 FROM presence_connections pc WHERE pc.user_id = users.id)
+		RETURNING id, last_seen, COALESCE(show_online, true), COALESCE(show_last_seen, true)
+	`, pq.Array(userIDs), now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offline []Presence
+	for rows.Next() {
+		p := Presence{WasOnline: true}
+		if err := rows.Scan(&p.UserID, &p.LastSeen, &p.ShowOnline, &p.ShowLastSeen); err != nil {
+			return nil, err
+		}
+		offline = append(offline, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return offline, tx.Commit()
+}
+
+// ResetPresence marks everyone offline. Only safe on startup of a single
+// replica, when no connections can be open anywhere.
+func ResetPresence() error {
+	if _, err := DB.Exec(`DELETE FROM presence_connections`); err != nil {
+		return err
+	}
+	_, err := DB.Exec(`UPDATE users SET is_online = false WHERE is_online`)
+	return err
+}
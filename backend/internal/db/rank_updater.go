@@ -5,6 +5,10 @@ import (
 	"log"
 )
 
+// RankChanged, when set, is called after a user's rank changed; main.go uses
+// it to tell the user over their websocket
+var RankChanged func(userID int, oldRank string, newRank string)
+
 // RankUpdateJob runs daily to recalculate ranks
 // Should be called once per day via scheduler (e.g., 00:01 UTC)
 func RankUpdateJob() error {
@@ -79,6 +83,9 @@ func UpdateUserRank(userID int) (bool, error) {
 		}
 
 		log.Printf("🎉 User %d promoted to %s (Points: %d)\n", userID, newRank, totalPoints)
+		if RankChanged != nil {
+			RankChanged(userID, currentRank, newRank)
+		}
 		return true, nil
 	}
 
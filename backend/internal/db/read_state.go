@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SeenBy is a member who read a message
+type SeenBy struct {
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	LastReadAt time.Time `json:"last_read_at"` // when their read position last moved
+}
+
+// unreadMessages joins the unread messages (m) of a membership (gm): messages
+// by others after the read position, or since joining without one
+const unreadMessages = `
+	LEFT JOIN group_read_state rs ON rs.group_id = gm.group_id AND rs.user_id = gm.user_id
+	LEFT JOIN messages m ON m.group_id = gm.group_id AND m.deleted_at IS NULL AND m.sender_id != gm.user_id
+		AND CASE WHEN rs.last_read_message_id IS NOT NULL
+			THEN m.id > rs.last_read_message_id
+			ELSE m.created_at >= COALESCE(gm.joined_at, 'epoch')
+		END`
+
+// MarkRead moves a member's read position forward to a message. It never
+// moves back; the current position is returned either way, and advanced
+// tells whether it moved.
+func MarkRead(groupID int, userID int, messageID int64) (lastRead int64, advanced bool, err error) {
+	err = DB.QueryRow(`
+		INSERT INTO group_read_state (group_id, user_id, last_read_message_id, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (group_id, user_id) DO UPDATE
+		SET last_read_message_id = EXCLUDED.last_read_message_id, updated_at = EXCLUDED.updated_at
+		WHERE group_read_state.last_read_message_id < EXCLUDED.last_read_message_id
+		RETURNING last_read_message_id
+	`, groupID, userID, messageID, time.Now().UTC()).Scan(&lastRead)
+	if err == nil {
+		return lastRead, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	err = DB.QueryRow(`SELECT last_read_message_id FROM group_read_state WHERE group_id = $1 AND user_id = $2`,
+		groupID, userID).Scan(&lastRead)
+	return lastRead, false, err
+}
+
+// LatestMessageID returns the id of the newest message of a group, 0 if it
+// has none
+func LatestMessageID(groupID int) (int64, error) {
+	var id int64
+	err := DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages WHERE group_id = $1`, groupID).Scan(&id)
+	return id, err
+}
+
+// UnreadCount counts a member's unread messages in a group
+func UnreadCount(groupID int, userID int) (int, error) {
+	var n int
+	err := DB.QueryRow(`SELECT COUNT(m.id) FROM group_members gm`+unreadMessages+`
+		WHERE gm.group_id = $1 AND gm.user_id = $2`, groupID, userID).Scan(&n)
+	return n, err
+}
+
+// UnreadCounts counts a user's unread messages in each of their groups
+func UnreadCounts(userID int) (map[int]int, error) {
+	rows, err := DB.Query(`SELECT gm.group_id, COUNT(m.id) FROM group_members gm`+unreadMessages+`
+		WHERE gm.user_id = $1 GROUP BY gm.group_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var groupID, n int
+		if err := rows.Scan(&groupID, &n); err != nil {
+			return nil, err
+		}
+		counts[groupID] = n
+	}
+	return counts, rows.Err()
+}
+
+// GetSeenBy lists the members other than the sender who have read up to a
+// message, most recent reader first
+func GetSeenBy(groupID int, messageID int64, senderID int) ([]SeenBy, error) {
+	rows, err := DB.Query(`
+		SELECT rs.user_id, COALESCE(NULLIF(u.username, ''), u.email, ''), rs.updated_at
+		FROM group_read_state rs
+		JOIN group_members gm ON gm.group_id = rs.group_id AND gm.user_id = rs.user_id
+		JOIN users u ON u.id = rs.user_id
+		WHERE rs.group_id = $1 AND rs.last_read_message_id >= $2 AND rs.user_id != $3
+		ORDER BY rs.updated_at DESC
+	`, groupID, messageID, senderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make([]SeenBy, 0)
+	for rows.Next() {
+		var s SeenBy
+		if err := rows.Scan(&s.UserID, &s.Username, &s.LastReadAt); err != nil {
+			return nil, err
+		}
+		seen = append(seen, s)
+	}
+	return seen, rows.Err()
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchResult is a message or shared file matching a search
+type SearchResult struct {
+	Kind        string    `json:"kind"` // message, resource
+	ID          int64     `json:"id"`
+	GroupID     int       `json:"group_id"`
+	GroupName   string    `json:"group_name"`
+	SenderID    int       `json:"sender_id"` // the uploader for resources
+	SenderName  string    `json:"sender_name"`
+	MessageType string    `json:"message_type,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Snippet     string    `json:"snippet"` // HTML escaped, matches wrapped in <mark>
+	CreatedAt   time.Time `json:"created_at"`
+	Rank        float64   `json:"rank"`
+}
+
+// SearchOptions narrows a search. Zero values don't filter.
+type SearchOptions struct {
+	Query    string // web search syntax: words, "phrases", or, -exclusions
+	ViewerID int
+	GroupID  int // 0 searches every group the viewer can see
+	SenderID int
+	From     *time.Time
+	To       *time.Time
+	Type     string // a message type, or "resource" for shared files only
+	Limit    int
+	Offset   int
+}
+
+// SearchKindResource is the Type that limits a search to shared files
+const SearchKindResource = "resource"
+
+// escapeHTML makes a text column safe to show as HTML once ts_headline has
+// added its <mark> tags
+func escapeHTML(column string) string {
+	return `replace(replace(replace(` + column + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`
+}
+
+// Search finds messages and shared files, best matches first. Deleted
+// messages are skipped. Without a GroupID only groups the viewer is a member
+// of or that allow viewing content without joining are searched.
+func Search(o SearchOptions) ([]SearchResult, error) {
+	args := []interface{}{o.Query}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	// filters shared by both halves; x is the message or resource
+	var common []string
+	if o.GroupID != 0 {
+		common = append(common, "x.group_id = "+arg(o.GroupID))
+	} else {
+		common = append(common, `(g.allow_content_view_without_join OR EXISTS(
+			SELECT 1 FROM group_members gm WHERE gm.group_id = x.group_id AND gm.user_id = `+arg(o.ViewerID)+`))`)
+	}
+	if o.From != nil {
+		common = append(common, "x.created_at >= "+arg(o.From.UTC()))
+	}
+	if o.To != nil {
+		common = append(common, "x.created_at < "+arg(o.To.UTC()))
+	}
+
+	var parts []string
+	if o.Type != SearchKindResource {
+		where := append([]string{"x.search_vector @@ s.q", "x.deleted_at IS NULL"}, common...)
+		if o.SenderID != 0 {
+			where = append(where, "x.sender_id = "+arg(o.SenderID))
+		}
+		if o.Type != "" {
+			where = append(where, "COALESCE(x.message_type, 'text') = "+arg(o.Type))
+		}
+		parts = append(parts, `
+			SELECT 'message' AS kind, x.id::bigint AS id, x.group_id, g.name AS group_name,
+				x.sender_id, COALESCE(x.sender_name, '') AS sender_name,
+				COALESCE(x.message_type, 'text') AS message_type, '' AS url,
+				x.content AS doc,
+				x.created_at, ts_rank(x.search_vector, s.q) AS rank
+			FROM messages x JOIN groups g ON g.id = x.group_id CROSS JOIN search s
+			WHERE `+strings.Join(where, " AND "))
+	}
+	if o.Type == "" || o.Type == SearchKindResource {
+		where := append([]string{"x.search_vector @@ s.q"}, common...)
+		if o.SenderID != 0 {
+			where = append(where, "x.uploaded_by = "+arg(o.SenderID))
+		}
+		parts = append(parts, `
+			SELECT 'resource' AS kind, x.id::bigint AS id, x.group_id, g.name AS group_name,
+				x.uploaded_by AS sender_id, COALESCE(NULLIF(u.username, ''), u.email, '') AS sender_name,
+				'' AS message_type, x.file_path AS url, x.filename AS doc,
+				x.created_at, ts_rank(x.search_vector, s.q) AS rank
+			FROM group_resources x JOIN groups g ON g.id = x.group_id
+			LEFT JOIN users u ON u.id = x.uploaded_by CROSS JOIN search s
+			WHERE `+strings.Join(where, " AND "))
+	}
+
+	// snippets are only built for the page that is returned
+	query := `
+		WITH search AS (SELECT websearch_to_tsquery('english', $1) AS q)
+		SELECT hits.kind, hits.id, hits.group_id, hits.group_name, hits.sender_id, hits.sender_name,
+			hits.message_type, hits.url,
+			ts_headline('english', ` + escapeHTML("hits.doc") + `, s.q,
+				'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" … "'),
+			hits.created_at, hits.rank
+		FROM (` + strings.Join(parts, " UNION ALL ") + `
+			ORDER BY rank DESC, created_at DESC
+			LIMIT ` + arg(o.Limit) + ` OFFSET ` + arg(o.Offset) + `
+		) hits CROSS JOIN search s
+		ORDER BY hits.rank DESC, hits.created_at DESC`
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var res SearchResult
+		err := rows.Scan(&res.Kind, &res.ID, &res.GroupID, &res.GroupName, &res.SenderID, &res.SenderName,
+			&res.MessageType, &res.URL, &res.Snippet, &res.CreatedAt, &res.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
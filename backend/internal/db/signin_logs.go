@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"studybuddy/internal/config"
+)
+
+// failureStreakTTL bounds how far back failed logins count towards a lockout
+const failureStreakTTL = 24 * time.Hour
+
+// SigninLog is one login attempt
+type SigninLog struct {
+	ID        int       `json:"id"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordSignin logs a login attempt and returns its id. userID is 0 when the
+// email does not belong to an account.
+func RecordSignin(userID int, email string, ip string, userAgent string, success bool) (int, error) {
+	var uid sql.NullInt64
+	if userID != 0 {
+		uid = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	var id int
+	err := DB.QueryRow(`
+		INSERT INTO signin_logs (user_id, email, ip_address, user_agent, success)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, uid, email, ip, userAgent, success).Scan(&id)
+	return id, err
+}
+
+// LoginRetryAfter returns how long logins for email or from ip are locked,
+// or 0 if they may proceed
+func LoginRetryAfter(email string, ip string, policy config.AuthConfig) (time.Duration, error) {
+	// failures for the account since its last successful login
+	var accountFailures int
+	var sinceAccount sql.NullFloat64
+	err := DB.QueryRow(`
+		SELECT COUNT(*), EXTRACT(EPOCH FROM NOW() - MAX(created_at))
+		FROM signin_logs
+		WHERE email = $1 AND success = FALSE
+		AND created_at > NOW() - $2 * INTERVAL '1 second'
+		AND created_at > COALESCE((SELECT MAX(created_at) FROM signin_logs WHERE email = $1 AND success), 'epoch')
+	`, email, failureStreakTTL.Seconds()).Scan(&accountFailures, &sinceAccount)
+	if err != nil {
+		return 0, err
+	}
+
+	var ipFailures int
+	var sinceIP sql.NullFloat64
+	err = DB.QueryRow(`
+		SELECT COUNT(*), EXTRACT(EPOCH FROM NOW() - MAX(created_at))
+		FROM signin_logs
+		WHERE ip_address = $1 AND success = FALSE
+		AND created_at > NOW() - $2 * INTERVAL '1 second'
+	`, ip, policy.LoginFailureWindow.Seconds()).Scan(&ipFailures, &sinceIP)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := lockoutRemaining(accountFailures, policy.MaxLoginFailures, sinceAccount, policy.LoginLockout)
+	if w := lockoutRemaining(ipFailures, policy.MaxIPLoginFailures, sinceIP, policy.LoginLockout); w > wait {
+		wait = w
+	}
+	return wait, nil
+}
+
+// lockoutRemaining doubles the lockout for every failure past the limit, up
+// to failureStreakTTL, counted from the latest failure
+func lockoutRemaining(failures int, limit int, sinceLast sql.NullFloat64, base time.Duration) time.Duration {
+	if failures < limit || !sinceLast.Valid {
+		return 0
+	}
+	lockout := base
+	for i := limit; i < failures && lockout < failureStreakTTL; i++ {
+		lockout *= 2
+	}
+	if lockout > failureStreakTTL {
+		lockout = failureStreakTTL
+	}
+	remaining := lockout - time.Duration(sinceLast.Float64*float64(time.Second))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// IsNewDevice reports whether the user has signed in before, but never with
+// this user agent. A user's very first login is not a new device.
+func IsNewDevice(userID int, userAgent string) (bool, error) {
+	var hasLogins, seen bool
+	err := DB.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM signin_logs WHERE user_id = $1 AND success),
+			EXISTS(SELECT 1 FROM signin_logs WHERE user_id = $1 AND success AND user_agent = $2)
+	`, userID, userAgent).Scan(&hasLogins, &seen)
+	if err != nil {
+		return false, err
+	}
+	return hasLogins && !seen, nil
+}
+
+// GetSigninHistory lists recent login attempts on a user's account, newest first
+func GetSigninHistory(userID int, limit int) ([]SigninLog, error) {
+	rows, err := DB.Query(`
+		SELECT id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), success, created_at
+		FROM signin_logs
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := make([]SigninLog, 0)
+	for rows.Next() {
+		var l SigninLog
+		if err := rows.Scan(&l.ID, &l.IPAddress, &l.UserAgent, &l.Success, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
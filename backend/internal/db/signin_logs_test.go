@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLockoutRemaining(t *testing.T) {
+	ago := func(d time.Duration) sql.NullFloat64 {
+		return sql.NullFloat64{Float64: d.Seconds(), Valid: true}
+	}
+	tests := []struct {
+		name      string
+		failures  int
+		sinceLast sql.NullFloat64
+		want      time.Duration
+	}{
+		{"below the limit", 4, ago(0), 0},
+		{"no failures", 0, sql.NullFloat64{}, 0},
+		{"at the limit", 5, ago(0), time.Minute},
+		{"partly waited", 5, ago(20 * time.Second), 40 * time.Second},
+		{"waited out", 5, ago(2 * time.Minute), 0},
+		{"doubles per failure", 6, ago(0), 2 * time.Minute},
+		{"doubles again", 8, ago(time.Minute), 7 * time.Minute},
+		{"capped", 40, ago(0), failureStreakTTL},
+		{"capped and partly waited", 40, ago(time.Hour), failureStreakTTL - time.Hour},
+	}
+	for _, tt := range tests {
+		if got := lockoutRemaining(tt.failures, 5, tt.sinceLast, time.Minute); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued at a time
+const RecoveryCodeCount = 10
+
+// TwoFactor is a user's TOTP enrollment state
+type TwoFactor struct {
+	Secret   string // empty when never enrolled
+	Enabled  bool
+	LastStep int64 // last accepted TOTP step, 0 for none
+}
+
+// GetTwoFactor loads the TOTP state of a user
+func GetTwoFactor(userID int) (*TwoFactor, error) {
+	var secret sql.NullString
+	var enabled bool
+	var lastStep sql.NullInt64
+	err := DB.QueryRow(`SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step FROM users WHERE id = $1`, userID).
+		Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactor{Secret: secret.String, Enabled: enabled && secret.Valid, LastStep: lastStep.Int64}, nil
+}
+
+// SetPendingTOTPSecret starts enrollment; the secret is not enforced until
+// EnableTOTP is called
+func SetPendingTOTPSecret(userID int, secret string) error {
+	_, err := DB.Exec(`
+		UPDATE users SET totp_secret = $2, totp_last_step = NULL
+		WHERE id = $1 AND totp_enabled_at IS NULL
+	`, userID, secret)
+	return err
+}
+
+// EnableTOTP turns on 2FA and stores a fresh set of recovery codes
+func EnableTOTP(userID int, step int64, codeHashes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2
+		WHERE id = $1 AND totp_secret IS NOT NULL
+	`, userID, step); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP turns off 2FA and drops the secret and recovery codes
+func DisableTOTP(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as used. It returns false if that step or a later
+// one was already accepted, i.e. the code is being replayed.
+func UseTOTPStep(userID int, step int64) (bool, error) {
+	res, err := DB.Exec(`
+		UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// NewRecoveryCodes returns codes formatted for display and their hashes
+func NewRecoveryCodes() (codes []string, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, HashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// NormalizeRecoveryCode strips the formatting users may type along with a code
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// ReplaceRecoveryCodes invalidates all existing recovery codes of a user
+func ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes a recovery code; each code works once
+func UseRecoveryCode(userID int, code string) (bool, error) {
+	res, err := DB.Exec(`
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, HashToken(NormalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrSessionInvalid is returned when a refresh token is unknown, expired or revoked
+var ErrSessionInvalid = errors.New("session invalid")
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token is
+// presented again; the session is revoked because the token was likely stolen
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// UserSession is one signed-in device
+type UserSession struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// NewRefreshToken returns a random opaque token and its storage hash
+func NewRefreshToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken hashes an opaque token for storage; tokens are never stored raw
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession stores a new session for a sign-in and returns its id
+func CreateSession(userID int, signinLogID *int, tokenHash string, ip string, userAgent string, ttl time.Duration) (int, error) {
+	var sessionID int
+	err := DB.QueryRow(`
+		INSERT INTO user_sessions (user_id, signin_log_id, refresh_token_hash, ip_address, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, userID, signinLogID, tokenHash, ip, userAgent, time.Now().UTC().Add(ttl)).Scan(&sessionID)
+	return sessionID, err
+}
+
+// RotateSession swaps the refresh token of the session that owns oldHash for
+// newHash and extends its expiry. It returns the session and user ids.
+func RotateSession(oldHash string, newHash string, ttl time.Duration) (int, int, error) {
+	var sessionID, userID int
+	err := DB.QueryRow(`
+		UPDATE user_sessions
+		SET previous_token_hash = refresh_token_hash,
+		    refresh_token_hash = $2,
+		    last_used_at = NOW(),
+		    expires_at = $3
+		WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id
+	`, oldHash, newHash, time.Now().UTC().Add(ttl)).Scan(&sessionID, &userID)
+	if err == nil {
+		return sessionID, userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+
+	// A token that was already rotated out means two parties hold it
+	res, err := DB.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE previous_token_hash = $1 AND revoked_at IS NULL
+	`, oldHash)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return 0, 0, ErrRefreshTokenReused
+	}
+	return 0, 0, ErrSessionInvalid
+}
+
+// IsSessionActive reports whether the session exists for the user and has
+// not been revoked or expired
+func IsSessionActive(sessionID int, userID int) (bool, error) {
+	var active bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_sessions
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+		)
+	`, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// RevokeSession revokes one of the user's sessions; false if none matched
+func RevokeSession(sessionID int, userID int) (bool, error) {
+	res, err := DB.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeUserSessions revokes every session of a user except keepSessionID
+// (pass 0 to revoke all) and returns how many were revoked
+func RevokeUserSessions(userID int, keepSessionID int) (int64, error) {
+	res, err := DB.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetUserSessions lists a user's signed-in devices, most recent first
+func GetUserSessions(userID int, currentSessionID int) ([]UserSession, error) {
+	rows, err := DB.Query(`
+		SELECT id, user_id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]UserSession, 0)
+	for rows.Next() {
+		var s UserSession
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IPAddress, &s.UserAgent, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		s.Current = s.ID == currentSessionID
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+
+	"github.com/gorilla/mux"
+)
+
+// Platform administration. Every route here is wrapped in
+// auth.RequireScope(auth.ScopeAdmin) and every change is written to the
+// admin audit log.
+
+type AdminRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type AdminModerationRequest struct {
+	Hours  int    `json:"hours,omitempty"` // suspension length
+	Reason string `json:"reason"`
+}
+
+type AdminPointsRequest struct {
+	Points int    `json:"points"`
+	Reason string `json:"reason"`
+}
+
+// audit records an admin action; failures are logged but don't undo the action
+func audit(r *http.Request, action string, targetType string, targetID int, details map[string]interface{}) {
+	adminID := auth.UserID(r.Context())
+	if err := db.RecordAdminAction(adminID, action, targetType, targetID, details, clientIP(r)); err != nil {
+		fmt.Printf("Failed to write audit log (%s by %d): %v\n", action, adminID, err)
+	}
+}
+
+// pageParams reads limit and offset query params
+func pageParams(r *http.Request, defaultLimit int, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// adminTarget parses the user id of the route and makes sure the user exists
+func adminTarget(w http.ResponseWriter, r *http.Request) (*db.AdminUser, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return nil, false
+	}
+	u, err := db.GetAdminUser(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+// AdminListUsers lists and searches users by name or email
+// Endpoint: GET /api/admin/users?q=&limit=50&offset=0
+func AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset := pageParams(r, 50, 200)
+	users, total, err := db.SearchUsers(strings.TrimSpace(r.URL.Query().Get("q")), limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to list users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"total": total,
+	})
+}
+
+// AdminGetUser returns one user including moderation state
+// Endpoint: GET /api/admin/users/{id}
+func AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// revokeSessions signs a user out everywhere after a moderation change. The
+// change itself is already saved, so a failure is reported as such and the
+// admin can retry the request.
+func revokeSessions(w http.ResponseWriter, userID int, done string) bool {
+	if _, err := db.RevokeUserSessions(userID, 0); err != nil {
+		fmt.Printf("Failed to revoke sessions for user %d: %v\n", userID, err)
+		http.Error(w, done+", but signing them out failed; retry to sign them out", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// AdminSetRole promotes or demotes a user
+// Endpoint: PUT /api/admin/users/{id}/role
+func AdminSetRole(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	var req AdminRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Role != db.RoleUser && req.Role != db.RoleAdmin) {
+		http.Error(w, "role must be \"user\" or \"admin\"", http.StatusBadRequest)
+		return
+	}
+	if u.ID == auth.UserID(r.Context()) {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.SetPlatformRole(u.ID, req.Role); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "set_role", "user", u.ID, map[string]interface{}{"from": u.PlatformRole, "to": req.Role})
+	// existing access tokens carry the old scopes
+	if !revokeSessions(w, u.ID, "Role updated") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role updated"})
+}
+
+// AdminSuspendUser blocks a user from signing in for a number of hours and
+// signs them out everywhere
+// Endpoint: POST /api/admin/users/{id}/suspend
+func AdminSuspendUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	var req AdminModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hours <= 0 {
+		http.Error(w, "hours must be positive", http.StatusBadRequest)
+		return
+	}
+	if u.ID == auth.UserID(r.Context()) {
+		http.Error(w, "You cannot suspend yourself", http.StatusBadRequest)
+		return
+	}
+
+	until := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+	if err := db.SuspendUser(u.ID, until, req.Reason); err != nil {
+		http.Error(w, "Failed to suspend user", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "suspend_user", "user", u.ID, map[string]interface{}{"hours": req.Hours, "until": until.UTC(), "reason": req.Reason})
+	if !revokeSessions(w, u.ID, "User suspended") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "User suspended", "suspended_until": until.UTC()})
+}
+
+// AdminBanUser blocks a user from signing in until unbanned
+// Endpoint: POST /api/admin/users/{id}/ban
+func AdminBanUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	var req AdminModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if u.ID == auth.UserID(r.Context()) {
+		http.Error(w, "You cannot ban yourself", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.BanUser(u.ID, req.Reason); err != nil {
+		http.Error(w, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "ban_user", "user", u.ID, map[string]interface{}{"reason": req.Reason})
+	if !revokeSessions(w, u.ID, "User banned") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User banned"})
+}
+
+// AdminUnbanUser lifts bans and suspensions
+// Endpoint: POST /api/admin/users/{id}/unban
+func AdminUnbanUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := db.LiftUserRestrictions(u.ID); err != nil {
+		http.Error(w, "Failed to unban user", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "unban_user", "user", u.ID, map[string]interface{}{
+		"was_banned":    u.BannedAt != nil,
+		"was_suspended": u.SuspendedUntil != nil,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Restrictions lifted"})
+}
+
+// AdminRemovePoints deducts points, e.g. ones farmed by spam
+// Endpoint: POST /api/admin/users/{id}/points/remove
+func AdminRemovePoints(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	var req AdminPointsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Points <= 0 {
+		http.Error(w, "points must be positive", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.RemovePoints(u.ID, req.Points, "admin: "+req.Reason); err != nil {
+		http.Error(w, "Failed to remove points", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "remove_points", "user", u.ID, map[string]interface{}{"points": req.Points, "reason": req.Reason})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Points removed"})
+}
+
+// AdminGetUserSignins shows a user's recent login attempts
+// Endpoint: GET /api/admin/users/{id}/signins?limit=50
+func AdminGetUserSignins(w http.ResponseWriter, r *http.Request) {
+	u, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := pageParams(r, 50, 500)
+
+	logs, err := db.GetSigninHistory(u.ID, limit)
+	if err != nil {
+		http.Error(w, "Failed to load sign-in history", http.StatusInternalServerError)
+		return
+	}
+	// reading someone's IP history is audited too
+	audit(r, "view_signins", "user", u.ID, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
+
+// AdminDeleteGroup deletes any group regardless of membership
+// Endpoint: DELETE /api/admin/groups/{id}
+func AdminDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+	var req AdminModerationRequest
+	// body is optional
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var name string
+	var creatorID sql.NullInt64
+	err = db.DB.QueryRow(`SELECT name, created_by FROM groups WHERE id=$1`, groupID).Scan(&name, &creatorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load group", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM groups WHERE id=$1`, groupID); err != nil {
+		http.Error(w, "failed to delete group", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "delete_group", "group", groupID, map[string]interface{}{
+		"name":       name,
+		"created_by": creatorID.Int64,
+		"reason":     req.Reason,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Group deleted"})
+}
+
+// AdminGetAuditLog lists admin actions, optionally for one target
+// Endpoint: GET /api/admin/audit?target_type=user&target_id=12&limit=50&offset=0
+func AdminGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit, offset := pageParams(r, 50, 200)
+	targetID, _ := strconv.Atoi(r.URL.Query().Get("target_id"))
+
+	entries, err := db.GetAuditLog(r.URL.Query().Get("target_type"), targetID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
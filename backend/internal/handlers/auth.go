@@ -3,20 +3,21 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
 
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte("supersecretkey") // ⚠️ Use env var in prod
-
 type AuthRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -24,7 +25,9 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
 }
 
 func Signup(w http.ResponseWriter, r *http.Request) {
@@ -40,13 +43,20 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.DB.Exec(`INSERT INTO users (username, email, password, created_at)
-	VALUES ($1, $2, $3, $4)`, req.Username, strings.ToLower(req.Email), string(hash), time.Now())
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	var userID int
+	err = db.DB.QueryRow(`INSERT INTO users (username, email, password, created_at)
+	VALUES ($1, $2, $3, $4) RETURNING id`, req.Username, email, string(hash), time.Now()).Scan(&userID)
 	if err != nil {
 		http.Error(w, "Email already exists or DB error", http.StatusConflict)
 		return
 	}
 
+	// Signup still succeeds if the mail can't be sent; the user can resend it
+	if err := sendVerificationEmail(userID, email); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %v\n", userID, err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte(`{"message":"Signup successful"}`))
 }
@@ -58,114 +68,199 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	ip := clientIP(r)
+	userAgent := r.Header.Get("User-Agent")
+
+	// Locked accounts and IPs are rejected before the password is checked
+	if !checkLoginThrottle(w, email, ip) {
+		return
+	}
+
 	var hashedPassword string
 	var userID int
-	err := db.DB.QueryRow(`SELECT id, password FROM users WHERE LOWER(email) = LOWER($1)`, req.Email).
+	err := db.DB.QueryRow(`SELECT id, password FROM users WHERE LOWER(email) = $1`, email).
 		Scan(&userID, &hashedPassword)
 
 	if err == sql.ErrNoRows || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
+		if _, err := db.RecordSignin(userID, email, ip, userAgent, false); err != nil {
+			fmt.Printf("Failed to log failed sign-in: %v\n", err)
+		}
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	loginOrChallenge(w, r, userID, email)
+}
+
+// loginOrChallenge finishes a login after the first factor. With 2FA on it
+// only hands out a challenge token; the login is finished by LoginTwoFactor.
+func loginOrChallenge(w http.ResponseWriter, r *http.Request, userID int, email string) {
+	if accountBlocked(w, userID) {
+		return
+	}
+	if tf, err := db.GetTwoFactor(userID); err == nil && tf.Enabled {
+		challenge, err := auth.IssueChallengeToken(userID)
+		if err != nil {
+			http.Error(w, "Token generation failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int64(auth.ChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	completeLogin(w, r, userID, email)
+}
+
+// accountBlocked rejects logins of banned and suspended users with a 403
+func accountBlocked(w http.ResponseWriter, userID int) bool {
+	blocked, until, err := db.GetAccountRestriction(userID)
+	if err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return true
+	}
+	if !blocked {
+		return false
+	}
+	if until != nil {
+		http.Error(w, "Account suspended until "+until.UTC().Format(time.RFC3339), http.StatusForbidden)
+	} else {
+		http.Error(w, "Account banned", http.StatusForbidden)
+	}
+	return true
+}
+
+// checkLoginThrottle rejects logins for locked accounts and IPs with a 429
+func checkLoginThrottle(w http.ResponseWriter, email string, ip string) bool {
+	retryAfter, err := db.LoginRetryAfter(email, ip, appConfig.Auth)
+	if err != nil {
+		fmt.Printf("Failed to check login throttle: %v\n", err)
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// completeLogin records a successful sign-in and starts a session once every
+// factor has been checked
+func completeLogin(w http.ResponseWriter, r *http.Request, userID int, email string) {
+	ip := clientIP(r)
+	userAgent := r.Header.Get("User-Agent")
+
 	// Update login streak and award points
 	if err := db.UpdateLoginStreak(userID); err != nil {
 		// Log error but don't fail login
 		fmt.Printf("Failed to update login streak for user %d: %v\n", userID, err)
 	}
 
+	newDevice, err := db.IsNewDevice(userID, userAgent)
+	if err != nil {
+		fmt.Printf("Failed to check sign-in device for user %d: %v\n", userID, err)
+	}
+
 	// Log the sign-in
-	ip := r.RemoteAddr
-	userAgent := r.Header.Get("User-Agent")
-	_, err = db.DB.Exec(`INSERT INTO signin_logs (user_id, ip_address, user_agent) VALUES ($1, $2, $3)`,
-		userID, ip, userAgent)
+	var signinLogID *int
+	logID, err := db.RecordSignin(userID, email, ip, userAgent, true)
 	if err != nil {
 		// Log error but don't fail login
 		fmt.Printf("Failed to log sign-in: %v\n", err)
+	} else {
+		signinLogID = &logID
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	})
+	if newDevice {
+		notifyNewSignin(userID, ip, userAgent)
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// Start a server-side session so this device can be refreshed and revoked
+	refreshToken, refreshHash, err := db.NewRefreshToken()
 	if err != nil {
 		http.Error(w, "Token generation failed", http.StatusInternalServerError)
 		return
 	}
+	sessionID, err := db.CreateSession(userID, signinLogID, refreshHash, ip, userAgent, appConfig.Auth.RefreshTTL)
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
 
-	json.NewEncoder(w).Encode(AuthResponse{Token: tokenString})
+	writeTokens(w, userID, sessionID, refreshToken)
 }
 
-// returns user id (int) and error if token missing/invalid
-func GetUserIDFromRequest(r *http.Request) (int, error) {
-	auth := r.Header.Get("Authorization")
-	if auth == "" {
-		return 0, fmt.Errorf("no auth header")
+// notifyNewSignin warns the user about a login from a device they haven't
+// used before, unless they turned these alerts off
+func notifyNewSignin(userID int, ip string, userAgent string) {
+	var enabled bool
+	if err := db.DB.QueryRow(`SELECT COALESCE(notify_new_signin, TRUE) FROM users WHERE id=$1`, userID).Scan(&enabled); err != nil || !enabled {
+		return
 	}
-	// expected: "Bearer <token>"
-	parts := strings.Split(auth, " ")
-	if len(parts) != 2 {
-		return 0, fmt.Errorf("invalid auth header")
+	if userAgent == "" {
+		userAgent = "unknown browser"
+	}
+	err := db.CreateNotification(userID, "new_signin", "New sign-in",
+		fmt.Sprintf("Your account was signed in from a new device (%s, %s). If this wasn't you, change your password and sign out other sessions.", userAgent, ip),
+		nil, nil, nil)
+	if err != nil {
+		fmt.Printf("Failed to create sign-in notification for user %d: %v\n", userID, err)
 	}
-	tokenStr := parts[1]
-
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		// validate alg if needed
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		return 0, fmt.Errorf("invalid token")
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, fmt.Errorf("invalid claims")
-	}
-	uidRaw, ok := claims["user_id"]
-	if !ok {
-		return 0, fmt.Errorf("no user_id in token")
-	}
-	// jwt stores numbers as float64 when decoded from JSON
-	var uid int
-	switch v := uidRaw.(type) {
-	case float64:
-		uid = int(v)
-	case int:
-		uid = v
-	default:
-		return 0, fmt.Errorf("invalid user_id type")
-	}
-	return uid, nil
 }
 
-func GetUserIDFromToken(tokenStr string) (int, error) {
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		return 0, errors.New("invalid token")
+// clientIP returns the address of the client. Behind a trusted proxy that is
+// the rightmost X-Forwarded-For hop that is not a trusted proxy itself, or
+// X-Real-IP; everyone else could forge those headers, so their requests are
+// attributed to the remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, errors.New("invalid claims")
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !appConfig.Auth.IsTrustedProxy(peer) {
+		return host
 	}
-	uidRaw, ok := claims["user_id"]
-	if !ok {
-		return 0, errors.New("no user_id")
+
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		hops = strings.Split(strings.Join(hops, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			if !appConfig.Auth.IsTrustedProxy(hop) {
+				return hop.Unmap().String()
+			}
+		}
+	} else if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
 	}
-	switch v := uidRaw.(type) {
-	case float64:
-		return int(v), nil
-	case int:
-		return v, nil
-	default:
-		return 0, errors.New("invalid user_id type")
+	return host
+}
+
+// writeTokens signs an access token for the session and writes the auth response
+func writeTokens(w http.ResponseWriter, userID int, sessionID int, refreshToken string) {
+	// roles are read on every refresh, so role changes apply within one token lifetime
+	role, err := db.GetPlatformRole(userID)
+	if err != nil {
+		role = db.RoleUser
 	}
+	tokenString, err := auth.IssueAccessToken(userID, sessionID, auth.ScopesForRole(role)...)
+	if err != nil {
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.TokenTTL().Seconds()),
+	})
 }
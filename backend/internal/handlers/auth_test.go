@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"studybuddy/internal/config"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("172.18.0.0/16")}
+	saved := appConfig
+	appConfig = cfg
+	t.Cleanup(func() { appConfig = saved })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct", "203.0.113.7:51234", nil, "203.0.113.7"},
+		{"direct ipv6", "[2001:db8::1]:443", nil, "2001:db8::1"},
+		// only trusted proxies may name the client
+		{"forged forwarded for", "203.0.113.7:51234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"forged real ip", "203.0.113.7:51234", map[string]string{"X-Real-IP": "198.51.100.1"}, "203.0.113.7"},
+		{"real ip", "172.18.0.5:40000", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"forwarded for", "172.18.0.5:40000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		// the client can prepend anything, so the rightmost untrusted hop counts
+		{"spoofed first hop", "172.18.0.5:40000", map[string]string{"X-Forwarded-For": "10.9.9.9, 198.51.100.1"}, "198.51.100.1"},
+		{"chained proxies", "172.18.0.5:40000", map[string]string{"X-Forwarded-For": "198.51.100.1, 172.18.0.9"}, "198.51.100.1"},
+		{"forwarded for wins", "172.18.0.5:40000", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "198.51.100.1"},
+		{"garbage", "172.18.0.5:40000", map[string]string{"X-Forwarded-For": "not-an-ip"}, "172.18.0.5"},
+		{"no headers", "172.18.0.5:40000", nil, "172.18.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package handlers
+
+import "studybuddy/internal/config"
+
+// appConfig holds the settings injected from main.go
+var appConfig = config.Default()
+
+// Configure injects the loaded config; call before serving requests
+func Configure(cfg *config.Config) {
+	appConfig = cfg
+}
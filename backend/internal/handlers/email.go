@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/mail"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Mailer delivers verification and reset emails; main.go replaces the
+// default with the configured driver
+var Mailer mail.Mailer = mail.LogMailer{}
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+	changeEmailTTL   = 24 * time.Hour
+)
+
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// appLink builds a frontend link carrying a mailed token
+func appLink(path string, token string) string {
+	return appConfig.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func sendVerificationEmail(userID int, email string) error {
+	token, err := db.CreateEmailToken(userID, db.TokenVerifyEmail, "", verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return Mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Verify your StudyBuddy email",
+		Body: fmt.Sprintf("Welcome to StudyBuddy!\n\nConfirm your email address by opening this link:\n%s\n\nThe link expires in %d hours.\n",
+			appLink("/verify-email", token), int(verifyEmailTTL.Hours())),
+	})
+}
+
+func sendEmailChangeConfirmation(userID int, newEmail string) error {
+	token, err := db.CreateEmailToken(userID, db.TokenChangeEmail, newEmail, changeEmailTTL)
+	if err != nil {
+		return err
+	}
+	return Mailer.Send(mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new StudyBuddy email",
+		Body: fmt.Sprintf("Confirm that you want to use this address for your StudyBuddy account:\n%s\n\nThe link expires in %d hours.\n",
+			appLink("/confirm-email", token), int(changeEmailTTL.Hours())),
+	})
+}
+
+// VerifyEmail confirms the address a verification link was sent to
+// Endpoint: POST /api/email/verify
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req EmailTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token required", http.StatusBadRequest)
+		return
+	}
+
+	t, err := db.ConsumeEmailToken(req.Token, db.TokenVerifyEmail)
+	if err != nil {
+		if errors.Is(err, db.ErrEmailTokenInvalid) {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Verification failed", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.MarkEmailVerified(t.UserID); err != nil {
+		http.Error(w, "Verification failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+// ResendVerification mails a fresh verification link to the current user
+// Endpoint: POST /api/email/verify/resend
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	var email string
+	var verifiedAt sql.NullTime
+	err := db.DB.QueryRow(`SELECT email, email_verified_at FROM users WHERE id=$1`, userID).Scan(&email, &verifiedAt)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if verifiedAt.Valid {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	if err := sendVerificationEmail(userID, email); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %v\n", userID, err)
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
+
+// ForgotPassword mails a reset link. The response is the same whether or not
+// the address has an account so it cannot be used to probe for users.
+// Endpoint: POST /api/password/forgot
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "email required", http.StatusBadRequest)
+		return
+	}
+
+	// send in the background so the response time does not leak either
+	go func(email string) {
+		var userID int
+		err := db.DB.QueryRow(`SELECT id, email FROM users WHERE LOWER(email) = LOWER($1)`, email).Scan(&userID, &email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				fmt.Printf("Password reset lookup failed: %v\n", err)
+			}
+			return
+		}
+
+		token, err := db.CreateEmailToken(userID, db.TokenResetPassword, "", resetPasswordTTL)
+		if err != nil {
+			fmt.Printf("Failed to create reset token for user %d: %v\n", userID, err)
+			return
+		}
+		err = Mailer.Send(mail.Message{
+			To:      email,
+			Subject: "Reset your StudyBuddy password",
+			Body: fmt.Sprintf("Someone asked to reset the password of your StudyBuddy account.\n\nChoose a new password here:\n%s\n\nThe link expires in %d minutes. If this wasn't you, you can ignore this email.\n",
+				appLink("/reset-password", token), int(resetPasswordTTL.Minutes())),
+		})
+		if err != nil {
+			fmt.Printf("Failed to send reset email to user %d: %v\n", userID, err)
+		}
+	}(strings.TrimSpace(req.Email))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for that email, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password from a reset link and signs out every
+// device
+// Endpoint: POST /api/password/reset
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < 6 {
+		http.Error(w, "New password must be at least 6 characters long", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := db.ConsumeEmailToken(req.Token, db.TokenResetPassword)
+	if err != nil {
+		if errors.Is(err, db.ErrEmailTokenInvalid) {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Password reset failed", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.DB.Exec(`UPDATE users SET password=$1 WHERE id=$2`, string(hash), t.UserID); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	// the link reached the inbox, so the address is proven too
+	if err := db.MarkEmailVerified(t.UserID); err != nil {
+		fmt.Printf("Failed to mark email verified for user %d: %v\n", t.UserID, err)
+	}
+	if _, err := db.RevokeUserSessions(t.UserID, 0); err != nil {
+		fmt.Printf("Failed to revoke sessions for user %d: %v\n", t.UserID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
+
+// ConfirmEmailChange applies an address change once the link sent to the new
+// address is opened
+// Endpoint: POST /api/profile/email/confirm
+func ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req EmailTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token required", http.StatusBadRequest)
+		return
+	}
+
+	t, err := db.ConsumeEmailToken(req.Token, db.TokenChangeEmail)
+	if err != nil {
+		if errors.Is(err, db.ErrEmailTokenInvalid) {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Email update failed", http.StatusInternalServerError)
+		return
+	}
+
+	var oldEmail string
+	db.DB.QueryRow(`SELECT email FROM users WHERE id=$1`, t.UserID).Scan(&oldEmail)
+
+	_, err = db.DB.Exec(`UPDATE users SET email=$1, email_verified_at=NOW() WHERE id=$2`, t.NewEmail, t.UserID)
+	if err != nil {
+		// someone else registered the address in the meantime
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
+
+	if oldEmail != "" {
+		err = Mailer.Send(mail.Message{
+			To:      oldEmail,
+			Subject: "Your StudyBuddy email was changed",
+			Body:    fmt.Sprintf("The email address of your StudyBuddy account was changed to %s.\n\nIf you didn't do this, reset your password right away.\n", t.NewEmail),
+		})
+		if err != nil {
+			fmt.Printf("Failed to notify old address of user %d: %v\n", t.UserID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email changed"})
+}
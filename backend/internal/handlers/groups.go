@@ -6,7 +6,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
+	"studybuddy/internal/models"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -52,14 +54,10 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var newID int
-	err = db.DB.QueryRow(
+	err := db.DB.QueryRow(
 		`INSERT INTO groups (name, username, description, created_by, is_public, allow_content_view_without_join, require_admin_approval, created_at, updated_at) 
 		 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW()) RETURNING id`,
 		req.Name, req.Username, req.Description, userID, req.IsPublic, req.AllowContentViewWithoutJoin, req.RequireAdminApproval,
@@ -157,11 +155,7 @@ func JoinGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Check if group exists and requires admin approval
 	var requireApproval bool
@@ -229,11 +223,7 @@ func LeaveGroup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid group id", http.StatusBadRequest)
 		return
 	}
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	res, err := db.DB.Exec(`DELETE FROM group_members WHERE group_id=$1 AND user_id=$2`, gid, userID)
 	if err != nil {
@@ -318,14 +308,10 @@ func GetGroup(w http.ResponseWriter, r *http.Request) {
 
 // GET /api/user/groups - return groups the authenticated user has joined
 func GetMyGroups(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	rows, err := db.DB.Query(`
-		SELECT g.id, g.name, g.description, g.created_by, g.created_at, gm.role,
+		SELECT g.id, g.name, g.description, g.created_by, g.created_at, gm.role, gm.muted,
 		  (SELECT COUNT(*) FROM group_members gm2 WHERE gm2.group_id = g.id) AS members_count
 		FROM groups g
 		JOIN group_members gm ON gm.group_id = g.id
@@ -345,14 +331,21 @@ func GetMyGroups(w http.ResponseWriter, r *http.Request) {
 		CreatedBy    int    `json:"created_by"`
 		CreatedAt    string `json:"created_at"`
 		Role         string `json:"role"`
+		Muted        bool   `json:"muted"`
 		MembersCount int    `json:"members_count"`
+		UnreadCount  int    `json:"unread_count"`
+	}
+
+	unread, err := db.UnreadCounts(userID)
+	if err != nil {
+		log.Printf("failed to count unread messages of user %d: %v", userID, err)
 	}
 
 	var res []outGroup
 	for rows.Next() {
 		var g outGroup
 		var createdAt sql.NullTime
-		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedBy, &createdAt, &g.Role, &g.MembersCount); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedBy, &createdAt, &g.Role, &g.Muted, &g.MembersCount); err != nil {
 			continue
 		}
 		if createdAt.Valid {
@@ -360,6 +353,7 @@ func GetMyGroups(w http.ResponseWriter, r *http.Request) {
 		} else {
 			g.CreatedAt = ""
 		}
+		g.UnreadCount = unread[g.ID]
 		res = append(res, g)
 	}
 
@@ -367,7 +361,8 @@ func GetMyGroups(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-// GET /api/groups/{id}/messages
+// GetGroupMessages pages through a group's chat history, see loadMessagePage
+// Endpoint: GET /api/groups/{id}/messages
 func GetGroupMessages(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	gidStr := vars["id"]
@@ -378,7 +373,7 @@ func GetGroupMessages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user ID if authenticated
-	userID, _ := GetUserIDFromRequest(r)
+	userID := auth.UserID(r.Context())
 
 	// Check if user has access to see messages
 	var isMember bool
@@ -406,63 +401,21 @@ func GetGroupMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.DB.Query(`SELECT id, group_id, sender_id, sender_name, content, created_at
-		FROM messages WHERE group_id=$1 ORDER BY created_at DESC LIMIT 100`, gid)
-	if err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
+	page, status, msg := loadMessagePage(gid, userID, r.URL.Query())
+	if status != 0 {
+		http.Error(w, msg, status)
 		return
 	}
-	defer rows.Close()
-
-	// build a stable response shape matching DB columns
-	type respMsg struct {
-		ID         int64  `json:"id"`
-		GroupID    int    `json:"group_id"`
-		SenderID   int    `json:"sender_id"`
-		SenderName string `json:"sender_name"`
-		Content    string `json:"content"`
-		CreatedAt  string `json:"created_at"`
-	}
 
-	var msgs []respMsg
-	for rows.Next() {
-		var id int64
-		var groupID int
-		var senderID int
-		var senderName sql.NullString
-		var content sql.NullString
-		var createdAt time.Time
-
-		if err := rows.Scan(&id, &groupID, &senderID, &senderName, &content, &createdAt); err != nil {
-			continue
-		}
-
-		m := respMsg{
-			ID:         id,
-			GroupID:    groupID,
-			SenderID:   senderID,
-			SenderName: senderName.String,
-			Content:    content.String,
-			CreatedAt:  createdAt.Format(time.RFC3339),
-		}
-		msgs = append(msgs, m)
-	}
-
-	// reverse to ascending order (oldest first)
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
-	}
-
-	json.NewEncoder(w).Encode(msgs)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
 }
 
-// PostGroupMessage - HTTP endpoint to post a message (fallback to WebSocket)
+// PostGroupMessage - HTTP endpoint to post a message (fallback to WebSocket).
+// Retries with the same Idempotency-Key header or clientTempId return the
+// message sent the first time.
 func PostGroupMessage(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	groupIDStr := vars["id"]
@@ -486,8 +439,11 @@ func PostGroupMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Content      string `json:"content"`
-		ClientTempID string `json:"clientTempId,omitempty"`
+		Content      string          `json:"content"`
+		MessageType  string          `json:"message_type,omitempty"` // text when left out
+		Payload      json.RawMessage `json:"payload,omitempty"`      // of poll, code and math messages
+		ReplyToID    int64           `json:"reply_to_id,omitempty"`
+		ClientTempID string          `json:"clientTempId,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -495,68 +451,53 @@ func PostGroupMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Content == "" {
-		http.Error(w, "content required", http.StatusBadRequest)
+	payload, content, reason := parseMessagePayload(req.MessageType, req.Content, req.Payload)
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
 		return
 	}
-
-	// Get sender name
-	var senderName string
-	err = db.DB.QueryRow(`SELECT username FROM users WHERE id=$1`, userID).Scan(&senderName)
-	if err != nil {
-		senderName = "Unknown"
+	if reason := messageTypeAllowed(groupID, userID, req.MessageType); reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+	if req.MessageType == "" {
+		req.MessageType = models.MessageTypeText
 	}
 
-	// Save message to database - ALWAYS use UTC
-	now := time.Now().UTC()
-	var messageID int64
-	err = db.DB.QueryRow(
-		`INSERT INTO messages (group_id, sender_id, sender_name, content, created_at) 
-		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		groupID, userID, senderName, req.Content, now,
-	).Scan(&messageID)
+	parent, reason := replyTarget(groupID, req.ReplyToID)
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
 
-	if err != nil {
-		http.Error(w, "Failed to save message", http.StatusInternalServerError)
+	key, ok := messageKey(r, req.ClientTempID)
+	if !ok {
+		http.Error(w, "idempotency key is too long", http.StatusBadRequest)
 		return
 	}
 
-	// Get group name
-	var groupName string
-	err = db.DB.QueryRow(`SELECT name FROM groups WHERE id=$1`, groupID).Scan(&groupName)
+	// saved and broadcast to connected clients like websocket messages
+	m, created, err := publishMessage(newMessage{
+		GroupID:      groupID,
+		SenderID:     userID,
+		Content:      content,
+		Type:         req.MessageType,
+		Payload:      payload,
+		Parent:       parent,
+		ClientTempID: req.ClientTempID,
+		Key:          key,
+	})
 	if err != nil {
-		groupName = "Group"
+		http.Error(w, "Failed to save message", http.StatusInternalServerError)
+		return
 	}
-
-	// Get all group members to notify them (except sender)
-	rows, err := db.DB.Query(
-		`SELECT user_id FROM group_members WHERE group_id=$1 AND user_id!=$2`,
-		groupID, userID,
-	)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var memberID int
-			if err := rows.Scan(&memberID); err == nil {
-				// Create notification for this member
-				notifyTitle := "New message in " + groupName
-				notifyMessage := senderName + ": " + req.Content
-				db.CreateNotification(memberID, "new_message", notifyTitle, notifyMessage, &groupID, nil, nil)
-			}
-		}
+	if !created {
+		w.Header().Set("Idempotent-Replayed", "true")
 	}
 
 	// Return the saved message with real ID
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":           messageID,
-		"clientTempId": req.ClientTempID,
-		"group_id":     groupID,
-		"sender_id":    userID,
-		"sender_name":  senderName,
-		"content":      req.Content,
-		"created_at":   now.Format(time.RFC3339),
-	})
+	json.NewEncoder(w).Encode(messageResponse(m, req.ClientTempID))
 }
 
 // Helper: Check if user is admin of group
@@ -648,8 +589,11 @@ func GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	viewerID := auth.UserID(r.Context())
+
 	rows, err := db.DB.Query(`
-		SELECT gm.id, gm.group_id, gm.user_id, u.username, gm.role, gm.joined_at
+		SELECT gm.id, gm.group_id, gm.user_id, u.username, gm.role, gm.joined_at,
+		  COALESCE(u.is_online, false), u.last_seen, COALESCE(u.show_online, true), COALESCE(u.show_last_seen, true)
 		FROM group_members gm
 		JOIN users u ON u.id = gm.user_id
 		WHERE gm.group_id = $1
@@ -662,22 +606,30 @@ func GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type MemberInfo struct {
-		ID       int    `json:"id"`
-		GroupID  int    `json:"group_id"`
-		UserID   int    `json:"user_id"`
-		Username string `json:"username"`
-		Role     string `json:"role"`
-		JoinedAt string `json:"joined_at"`
+		ID       int        `json:"id"`
+		GroupID  int        `json:"group_id"`
+		UserID   int        `json:"user_id"`
+		Username string     `json:"username"`
+		Role     string     `json:"role"`
+		JoinedAt string     `json:"joined_at"`
+		IsOnline bool       `json:"is_online"`
+		LastSeen *time.Time `json:"last_seen,omitempty"`
 	}
 
 	var members []MemberInfo
 	for rows.Next() {
 		var m MemberInfo
 		var joinedAt time.Time
-		if err := rows.Scan(&m.ID, &m.GroupID, &m.UserID, &m.Username, &m.Role, &joinedAt); err != nil {
+		var showOnline, showLastSeen bool
+		if err := rows.Scan(&m.ID, &m.GroupID, &m.UserID, &m.Username, &m.Role, &joinedAt,
+			&m.IsOnline, &m.LastSeen, &showOnline, &showLastSeen); err != nil {
 			continue
 		}
 		m.JoinedAt = joinedAt.Format(time.RFC3339)
+		// everyone sees their own presence
+		if m.UserID != viewerID {
+			m.IsOnline, m.LastSeen = visiblePresence(m.IsOnline, m.LastSeen, showOnline, showLastSeen)
+		}
 		members = append(members, m)
 	}
 
@@ -703,11 +655,7 @@ func RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	adminID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	adminID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, adminID) {
@@ -758,11 +706,7 @@ func MakeGroupAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	adminID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	adminID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, adminID) {
@@ -813,11 +757,7 @@ func RemoveGroupAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	adminID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	adminID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, adminID) {
@@ -860,11 +800,7 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, userID) {
@@ -903,11 +839,7 @@ func CanViewContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var allowWithoutJoin bool
 	err = db.DB.QueryRow(
@@ -942,11 +874,7 @@ func GetJoinRequests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, userID) {
@@ -1009,11 +937,7 @@ func ApproveJoinRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	adminID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	adminID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, adminID) {
@@ -1058,6 +982,8 @@ func ApproveJoinRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pushJoinDecision(userID, groupID, "approved", "")
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "join request approved"})
 }
@@ -1080,11 +1006,7 @@ func RejectJoinRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	adminID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	adminID := auth.UserID(r.Context())
 
 	// Check if requester is admin
 	if !IsGroupAdmin(groupID, adminID) {
@@ -1129,6 +1051,11 @@ func RejectJoinRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var userID int
+	if err := db.DB.QueryRow(`SELECT user_id FROM join_requests WHERE id=$1`, requestID).Scan(&userID); err == nil {
+		pushJoinDecision(userID, groupID, "rejected", req.Reason)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "join request rejected"})
 }
@@ -1142,8 +1069,7 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user ID from token
-	userID := r.Context().Value("userID").(int)
+	userID := auth.UserID(r.Context())
 
 	// Check if user is admin of this group
 	var isAdmin bool
@@ -1159,6 +1085,7 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	// Parse request body for password
 	type DeleteGroupRequest struct {
 		Password string `json:"password"`
+		TOTPCode string `json:"totp_code,omitempty"` // required when 2FA is on
 	}
 	var req DeleteGroupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1185,6 +1112,9 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid password", http.StatusUnauthorized)
 		return
 	}
+	if !requireSecondFactor(w, userID, req.TOTPCode) {
+		return
+	}
 
 	// Delete the group (cascade will handle related records)
 	result, err := db.DB.Exec(`DELETE FROM groups WHERE id=$1`, groupID)
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/models"
+)
+
+// mentionPattern finds @username, @admins and @all. The @ must not follow a
+// word character, so email addresses don't mention anyone.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w.@])@([A-Za-z0-9_][A-Za-z0-9_.-]*)`)
+
+// Mention feed page sizes (?limit=)
+const (
+	defaultMentionPage = 30
+	maxMentionPage     = 100
+)
+
+// MentionView is a message the user was mentioned in
+type MentionView struct {
+	Kind      string      `json:"kind"` // user, admins, all
+	GroupName string      `json:"group_name"`
+	Message   MessageView `json:"message"`
+}
+
+// parseMentions lists the usernames mentioned in a text, and whether it
+// mentions @admins or @all
+func parseMentions(text string) (usernames []string, admins bool, all bool) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// a sentence may end right after the name
+		name := strings.TrimRight(match[1], ".-")
+		switch lower := strings.ToLower(name); {
+		case lower == db.MentionAdmins:
+			admins = true
+		case lower == db.MentionAll:
+			all = true
+		case name != "" && !seen[lower]:
+			seen[lower] = true
+			usernames = append(usernames, name)
+		}
+	}
+	return usernames, admins, all
+}
+
+// messageMentions finds the members a message mentions. Only text and polls
+// are read: code and math use @ for other things, and announcements notify
+// every member anyway. @all is honored from group admins only. The sender
+// never mentions themselves.
+func messageMentions(m *models.Message) ([]db.Mention, error) {
+	if m.MessageType != models.MessageTypeText && m.MessageType != models.MessageTypePoll {
+		return nil, nil
+	}
+	usernames, admins, all := parseMentions(m.Content)
+	all = all && IsGroupAdmin(m.GroupID, m.SenderID)
+
+	kinds := make(map[int]string)
+	var order []int
+	add := func(ids []int, kind string) {
+		for _, id := range ids {
+			if _, ok := kinds[id]; !ok && id != m.SenderID {
+				kinds[id] = kind
+				order = append(order, id)
+			}
+		}
+	}
+	// the most direct mention wins
+	if len(usernames) > 0 {
+		ids, err := db.GroupMembersByUsername(m.GroupID, usernames)
+		if err != nil {
+			return nil, err
+		}
+		add(ids, db.MentionUser)
+	}
+	for _, group := range []struct {
+		mentioned  bool
+		adminsOnly bool
+		kind       string
+	}{{admins, true, db.MentionAdmins}, {all, false, db.MentionAll}} {
+		if !group.mentioned {
+			continue
+		}
+		ids, err := db.GroupMemberIDs(m.GroupID, group.adminsOnly)
+		if err != nil {
+			return nil, err
+		}
+		add(ids, group.kind)
+	}
+
+	mentions := make([]db.Mention, 0, len(order))
+	for _, id := range order {
+		mentions = append(mentions, db.Mention{UserID: id, Kind: kinds[id]})
+	}
+	return mentions, nil
+}
+
+// recordMentions stores who a new or edited message mentions and sends a
+// mention notification to those who weren't mentioned in it before, unless
+// they muted the group. It returns the newly mentioned users.
+func recordMentions(m *models.Message) []int {
+	mentions, err := messageMentions(m)
+	if err != nil {
+		fmt.Printf("Failed to resolve mentions of message %d: %v\n", m.ID, err)
+		return nil
+	}
+	added, err := db.SyncMentions(m.ID, mentions)
+	if err != nil {
+		fmt.Printf("Failed to store mentions of message %d: %v\n", m.ID, err)
+		return nil
+	}
+
+	if len(added) > 0 {
+		var groupName string
+		if err := db.DB.QueryRow(`SELECT name FROM groups WHERE id=$1`, m.GroupID).Scan(&groupName); err != nil {
+			groupName = "a group"
+		}
+		muted, err := db.MutedMembers(m.GroupID)
+		if err != nil {
+			fmt.Printf("Failed to load muted members of group %d: %v\n", m.GroupID, err)
+		}
+		for _, userID := range added {
+			if muted[userID] {
+				continue
+			}
+			db.CreateNotification(userID, "mention", m.SenderName+" mentioned you in "+groupName,
+				m.SenderName+": "+messagePreview(m), &m.GroupID, nil, nil)
+		}
+	}
+	return added
+}
+
+// GetMyMentions lists the messages the user was mentioned in, newest first.
+// Pass next_cursor as ?before= for older ones.
+// Endpoint: GET /api/user/mentions
+func GetMyMentions(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	q := r.URL.Query()
+
+	limit := defaultMentionPage
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxMentionPage)
+	}
+	var before int64
+	if v := q.Get("before"); v != "" {
+		var err error
+		if before, err = strconv.ParseInt(v, 10, 64); err != nil || before < 1 {
+			http.Error(w, "invalid before cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := db.GetMentions(userID, before, limit+1)
+	if err != nil {
+		http.Error(w, "failed to load mentions", http.StatusInternalServerError)
+		return
+	}
+	var next *int64
+	if len(entries) > limit {
+		entries = entries[:limit]
+		next = &entries[limit-1].MessageID
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.MessageID
+	}
+	msgs, err := db.GetMessagesByID(ids)
+	if err != nil {
+		http.Error(w, "failed to load mentions", http.StatusInternalServerError)
+		return
+	}
+	views, err := messageViews(msgs, userID)
+	if err != nil {
+		http.Error(w, "failed to load mentions", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[int64]MessageView, len(views))
+	for _, v := range views {
+		byID[v.ID] = v
+	}
+
+	mentions := make([]MentionView, 0, len(entries))
+	for _, e := range entries {
+		if v, ok := byID[e.MessageID]; ok {
+			mentions = append(mentions, MentionView{Kind: e.Kind, GroupName: e.GroupName, Message: v})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mentions":    mentions,
+		"next_cursor": next,
+	})
+}
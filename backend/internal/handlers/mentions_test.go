@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		text      string
+		usernames []string
+		admins    bool
+		all       bool
+	}{
+		{"no mentions here", nil, false, false},
+		{"@bob look", []string{"bob"}, false, false},
+		{"hi @bob, and @Bob again", []string{"bob"}, false, false},
+		{"ask @carol.", []string{"carol"}, false, false},
+		{"(@dave) and @erin_1 and @j.doe-x", []string{"dave", "erin_1", "j.doe-x"}, false, false},
+		{"line one\n@frank", []string{"frank"}, false, false},
+		// email addresses and handles stuck to words mention nobody
+		{"mail bob@example.com", nil, false, false},
+		{"a@b and x.@y", nil, false, false},
+		{"@ alone", nil, false, false},
+		{"@ADMINS please", nil, true, false},
+		{"@all @admins @grace", []string{"grace"}, true, true},
+	}
+	for _, tt := range tests {
+		usernames, admins, all := parseMentions(tt.text)
+		if !slices.Equal(usernames, tt.usernames) || admins != tt.admins || all != tt.all {
+			t.Errorf("parseMentions(%q) = %v, %v, %v, want %v, %v, %v",
+				tt.text, usernames, admins, all, tt.usernames, tt.admins, tt.all)
+		}
+	}
+}
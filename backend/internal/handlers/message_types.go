@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"studybuddy/internal/models"
+)
+
+// Limits of messages, in characters
+const (
+	maxTextLength         = 5000 // text and announcements
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 100
+	minPollOptions        = 2
+	maxPollOptions        = 10
+	maxCodeLength         = 20000
+	maxMathLength         = 5000
+)
+
+// maxFrameSize caps websocket frames: the largest message, a code block in
+// which every character is JSON escaped (\u0000 is 6 bytes), plus room for
+// the other fields of the frame. Larger frames get an error event.
+const maxFrameSize = maxCodeLength*6 + 4096
+
+// codeLanguage is a language tag of a code block, like go, c++ or c#
+var codeLanguage = regexp.MustCompile(`^[a-z0-9+#.-]{1,30}$`)
+
+// parseMessagePayload validates a message the user sends. Text messages and
+// announcements are their content; polls, code and math blocks are their
+// payload, which is returned normalized together with the plain text the
+// message is stored with (shown by older clients, searched and quoted). The
+// returned reason is meant for the user.
+func parseMessagePayload(messageType string, content string, raw json.RawMessage) (payload json.RawMessage, text string, reason string) {
+	switch messageType {
+	case "", models.MessageTypeText, models.MessageTypeAnnouncement:
+		if strings.TrimSpace(content) == "" {
+			return nil, "", "content required"
+		}
+		if utf8.RuneCountInString(content) > maxTextLength {
+			return nil, "", fmt.Sprintf("messages can have at most %d characters", maxTextLength)
+		}
+		return nil, content, ""
+
+	case models.MessageTypeFile:
+		return nil, "", "files are sent with the upload endpoint"
+
+	case models.MessageTypePoll:
+		var p models.PollPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, "", "invalid poll"
+		}
+		p.Question = strings.TrimSpace(p.Question)
+		if p.Question == "" || utf8.RuneCountInString(p.Question) > maxPollQuestionLength {
+			return nil, "", fmt.Sprintf("a poll needs a question of at most %d characters", maxPollQuestionLength)
+		}
+		if len(p.Options) < minPollOptions || len(p.Options) > maxPollOptions {
+			return nil, "", fmt.Sprintf("a poll needs %d to %d options", minPollOptions, maxPollOptions)
+		}
+		seen := make(map[string]bool, len(p.Options))
+		for i, option := range p.Options {
+			option = strings.TrimSpace(option)
+			if option == "" || utf8.RuneCountInString(option) > maxPollOptionLength {
+				return nil, "", fmt.Sprintf("poll options must have 1 to %d characters", maxPollOptionLength)
+			}
+			if seen[strings.ToLower(option)] {
+				return nil, "", "poll options must differ"
+			}
+			seen[strings.ToLower(option)] = true
+			p.Options[i] = option
+		}
+		if p.ClosesAt != nil {
+			if !p.ClosesAt.After(time.Now()) {
+				return nil, "", "closes_at must be in the future"
+			}
+			closesAt := p.ClosesAt.UTC()
+			p.ClosesAt = &closesAt
+		}
+		payload, _ = json.Marshal(p)
+		return payload, p.Question + "\n" + strings.Join(p.Options, "\n"), ""
+
+	case models.MessageTypeCode:
+		var c models.CodePayload
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, "", "invalid code block"
+		}
+		c.Language = strings.ToLower(strings.TrimSpace(c.Language))
+		if c.Language == "" {
+			c.Language = "text"
+		}
+		if !codeLanguage.MatchString(c.Language) {
+			return nil, "", "invalid code language"
+		}
+		if strings.TrimSpace(c.Code) == "" || utf8.RuneCountInString(c.Code) > maxCodeLength {
+			return nil, "", fmt.Sprintf("code must have 1 to %d characters", maxCodeLength)
+		}
+		payload, _ = json.Marshal(c)
+		return payload, c.Code, ""
+
+	case models.MessageTypeMath:
+		var m models.MathPayload
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, "", "invalid math block"
+		}
+		m.LaTeX = strings.TrimSpace(m.LaTeX)
+		if m.LaTeX == "" || utf8.RuneCountInString(m.LaTeX) > maxMathLength {
+			return nil, "", fmt.Sprintf("math must have 1 to %d characters", maxMathLength)
+		}
+		if !balancedBraces(m.LaTeX) {
+			return nil, "", "unbalanced braces in math"
+		}
+		payload, _ = json.Marshal(m)
+		return payload, m.LaTeX, ""
+	}
+	return nil, "", "unknown message type"
+}
+
+// messageTypeAllowed checks that a user may send a type of message to a
+// group: announcements are for group admins. The returned reason is meant for
+// the user.
+func messageTypeAllowed(groupID int, userID int, messageType string) string {
+	if messageType == models.MessageTypeAnnouncement && !IsGroupAdmin(groupID, userID) {
+		return "only group admins can post announcements"
+	}
+	return ""
+}
+
+// balancedBraces reports whether the groups of a LaTeX source are closed;
+// escaped braces (\{ and \}) are literal
+func balancedBraces(latex string) bool {
+	depth := 0
+	for i := 0; i < len(latex); i++ {
+		switch latex[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// messagePreview is how a message is shown in notifications; text is
+// shortened like a quote
+func messagePreview(m *models.Message) string {
+	switch m.MessageType {
+	case models.MessageTypeFile:
+		return "shared a file"
+	case models.MessageTypePoll:
+		var p models.PollPayload
+		json.Unmarshal(m.Payload, &p)
+		return "started a poll: " + p.Question
+	case models.MessageTypeCode:
+		return "shared a code snippet"
+	case models.MessageTypeMath:
+		return "shared a math block"
+	}
+	return quoteContent(m.Content)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"studybuddy/internal/models"
+)
+
+func TestMessagePreview(t *testing.T) {
+	poll, _ := json.Marshal(models.PollPayload{Question: "Lunch?", Options: []string{"yes", "no"}})
+	long := strings.Repeat("ä", maxTextLength)
+
+	tests := []struct {
+		m    models.Message
+		want string
+	}{
+		{models.Message{MessageType: models.MessageTypeText, Content: "hi"}, "hi"},
+		{models.Message{MessageType: models.MessageTypeAnnouncement, Content: "Exam moved"}, "Exam moved"},
+		{models.Message{MessageType: models.MessageTypePoll, Content: "Lunch?\nyes\nno", Payload: poll}, "started a poll: Lunch?"},
+		{models.Message{MessageType: models.MessageTypeCode, Content: "package main"}, "shared a code snippet"},
+		{models.Message{MessageType: models.MessageTypeMath, Content: `\frac{1}{2}`}, "shared a math block"},
+		{models.Message{MessageType: models.MessageTypeFile, Content: "notes.pdf"}, "shared a file"},
+	}
+	for _, tt := range tests {
+		if got := messagePreview(&tt.m); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.m.MessageType, got, tt.want)
+		}
+	}
+
+	// long text and announcements are shortened like quotes
+	for _, messageType := range []string{models.MessageTypeText, models.MessageTypeAnnouncement} {
+		got := messagePreview(&models.Message{MessageType: messageType, Content: long})
+		if utf8.RuneCountInString(got) != maxQuoteLength+1 || !strings.HasSuffix(got, "…") {
+			t.Errorf("%s preview has %d characters", messageType, utf8.RuneCountInString(got))
+		}
+	}
+}
+
+func TestParseMessagePayload(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name        string
+		messageType string
+		content     string
+		raw         string
+		wantPayload string
+		wantText    string
+		wantReason  string
+	}{
+		{"text", "", "hello", "", "", "hello", ""},
+		{"announcement", models.MessageTypeAnnouncement, "Exam moved", "", "", "Exam moved", ""},
+		{"blank text", models.MessageTypeText, "  \n", "", "", "", "content required"},
+		{"long text", models.MessageTypeText, strings.Repeat("a", maxTextLength+1), "", "", "", "at most 5000 characters"},
+		{"file", models.MessageTypeFile, "x", "", "", "", "upload endpoint"},
+		{"unknown", "video", "x", "", "", "", "unknown message type"},
+
+		{"poll", models.MessageTypePoll, "", `{"question":" Lunch? ","options":[" Pizza","Sushi "]}`,
+			`{"question":"Lunch?","options":["Pizza","Sushi"]}`, "Lunch?\nPizza\nSushi", ""},
+		{"poll without question", models.MessageTypePoll, "", `{"question":" ","options":["a","b"]}`, "", "", "needs a question"},
+		{"poll with one option", models.MessageTypePoll, "", `{"question":"q","options":["a"]}`, "", "", "2 to 10 options"},
+		{"poll with blank option", models.MessageTypePoll, "", `{"question":"q","options":["a"," "]}`, "", "", "1 to 100 characters"},
+		{"poll with equal options", models.MessageTypePoll, "", `{"question":"q","options":["Yes","yes"]}`, "", "", "must differ"},
+		{"poll closing later", models.MessageTypePoll, "", `{"question":"q","options":["a","b"],"closes_at":"` + future + `"}`, "", "q\na\nb", ""},
+		{"poll closed already", models.MessageTypePoll, "", `{"question":"q","options":["a","b"],"closes_at":"` + past + `"}`, "", "", "in the future"},
+		{"broken poll", models.MessageTypePoll, "", `{"question":`, "", "", "invalid poll"},
+
+		{"code", models.MessageTypeCode, "", `{"language":" Go ","code":"package main"}`,
+			`{"language":"go","code":"package main"}`, "package main", ""},
+		{"code without language", models.MessageTypeCode, "", `{"code":"x"}`, `{"language":"text","code":"x"}`, "x", ""},
+		{"code language with symbols", models.MessageTypeCode, "", `{"language":"c++","code":"x"}`, "", "x", ""},
+		{"bad code language", models.MessageTypeCode, "", `{"language":"<script>","code":"x"}`, "", "", "invalid code language"},
+		{"blank code", models.MessageTypeCode, "", `{"language":"go","code":"  "}`, "", "", "1 to 20000 characters"},
+
+		{"math", models.MessageTypeMath, "", `{"latex":" \\frac{1}{2} "}`, `{"latex":"\\frac{1}{2}"}`, `\frac{1}{2}`, ""},
+		{"unbalanced math", models.MessageTypeMath, "", `{"latex":"\\frac{1}{2"}`, "", "", "unbalanced braces"},
+		{"blank math", models.MessageTypeMath, "", `{"latex":""}`, "", "", "1 to 5000 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, text, reason := parseMessagePayload(tt.messageType, tt.content, json.RawMessage(tt.raw))
+			if tt.wantReason != "" {
+				if !strings.Contains(reason, tt.wantReason) {
+					t.Fatalf("got reason %q, want %q", reason, tt.wantReason)
+				}
+				return
+			}
+			if reason != "" {
+				t.Fatalf("rejected: %s", reason)
+			}
+			if tt.wantPayload != "" && string(payload) != tt.wantPayload {
+				t.Errorf("got payload %s, want %s", payload, tt.wantPayload)
+			}
+			if text != tt.wantText {
+				t.Errorf("got text %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestBalancedBraces(t *testing.T) {
+	tests := map[string]bool{
+		"":                   true,
+		"x^2":                true,
+		`\frac{1}{2}`:        true,
+		`\sqrt{\frac{a}{b}}`: true,
+		`\{x \mid x > 0\}`:   true, // escaped braces are literal
+		`\left\{ x \right.`:  true,
+		`\frac{1}{2`:         false,
+		`}{`:                 false,
+		`x}`:                 false,
+		`\\{`:                false, // an escaped backslash, then an open group
+	}
+	for latex, want := range tests {
+		if got := balancedBraces(latex); got != want {
+			t.Errorf("balancedBraces(%q) = %v, want %v", latex, got, want)
+		}
+	}
+}
+
+func TestMaxFrameSizeFitsLargestMessage(t *testing.T) {
+	// a code block of control characters, each escaped as \u00XX
+	code := strings.Repeat("\x01", maxCodeLength)
+	payload, _, reason := parseMessagePayload(models.MessageTypeCode, "", json.RawMessage(
+		`{"language":"text","code":`+string(mustMarshal(t, code))+`}`))
+	if reason != "" {
+		t.Fatal(reason)
+	}
+	frame := mustMarshal(t, WSMessage{
+		Action:       "message",
+		GroupID:      123456,
+		MessageType:  models.MessageTypeCode,
+		Payload:      payload,
+		ReplyToID:    123456789,
+		ClientTempID: strings.Repeat("t", 64),
+	})
+	if len(frame) > maxFrameSize {
+		t.Errorf("largest frame has %d bytes, limit is %d", len(frame), maxFrameSize)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/models"
+	"studybuddy/internal/ws"
+
+	"github.com/gorilla/mux"
+)
+
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// MessageView is a message as listed in a chat or thread
+type MessageView struct {
+	ID          int64                 `json:"id"`
+	GroupID     int                   `json:"group_id"`
+	SenderID    int                   `json:"sender_id"`
+	SenderName  string                `json:"sender_name"`
+	Content     string                `json:"content"`
+	MessageType string                `json:"message_type"`
+	Payload     json.RawMessage       `json:"payload,omitempty"`
+	Poll        *models.PollResults   `json:"poll,omitempty"` // the votes on a poll
+	CreatedAt   string                `json:"created_at"`
+	EditedAt    *string               `json:"edited_at,omitempty"`
+	Deleted     bool                  `json:"deleted,omitempty"`
+	ReplyToID   *int64                `json:"reply_to_id,omitempty"`
+	ReplyTo     *models.QuotedMessage `json:"reply_to,omitempty"`
+	ReplyCount  int                   `json:"reply_count"`
+	Pinned      bool                  `json:"pinned,omitempty"`
+}
+
+// MessagePage is a slice of a group's history, oldest first. The cursors
+// are message ids: pass PrevCursor as ?before= for older messages and
+// NextCursor as ?after= for newer ones. They are null at either end. Seq is
+// the group's latest event when the page was loaded, to connect with
+// ?since= so nothing sent in between is missed.
+type MessagePage struct {
+	Messages   []MessageView `json:"messages"`
+	PrevCursor *int64        `json:"prev_cursor"`
+	NextCursor *int64        `json:"next_cursor"`
+	Seq        int64         `json:"seq"`
+}
+
+// Message page sizes (?limit=)
+const (
+	defaultMessagePage = 100
+	maxMessagePage     = 200
+)
+
+// maxQuoteLength caps the quoted text shown above a reply
+const maxQuoteLength = 200
+
+// messageView renders a listed message. Deleted messages are shown as
+// tombstones without their content, and so are deleted quotes.
+func messageView(m *models.Message) MessageView {
+	v := MessageView{
+		ID:          m.ID,
+		GroupID:     m.GroupID,
+		SenderID:    m.SenderID,
+		SenderName:  m.SenderName,
+		Content:     m.Content,
+		MessageType: m.MessageType,
+		Payload:     m.Payload,
+		CreatedAt:   m.CreatedAt.Format(time.RFC3339),
+		ReplyToID:   m.ReplyToID,
+		ReplyCount:  m.ReplyCount,
+		Pinned:      m.Pinned,
+	}
+	if m.EditedAt != nil {
+		edited := m.EditedAt.Format(time.RFC3339)
+		v.EditedAt = &edited
+	}
+	if m.DeletedAt != nil {
+		v.Content = ""
+		v.Payload = nil
+		v.EditedAt = nil
+		v.Deleted = true
+	}
+	if m.ReplyTo != nil {
+		quoted := *m.ReplyTo
+		quoted.Content = quoteContent(quoted.Content)
+		if quoted.Deleted {
+			quoted.Content = ""
+		}
+		v.ReplyTo = &quoted
+	}
+	return v
+}
+
+// messageViews renders listed messages, with the tally of their polls as the
+// viewer sees it
+func messageViews(msgs []models.Message, viewerID int) ([]MessageView, error) {
+	polls := make(map[int64]int)
+	for i := range msgs {
+		if msgs[i].MessageType == models.MessageTypePoll && msgs[i].DeletedAt == nil {
+			var p models.PollPayload
+			json.Unmarshal(msgs[i].Payload, &p)
+			polls[msgs[i].ID] = len(p.Options)
+		}
+	}
+	results, err := db.GetPollResults(polls, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]MessageView, 0, len(msgs))
+	for i := range msgs {
+		v := messageView(&msgs[i])
+		v.Poll = results[msgs[i].ID]
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// loadMessagePage reads one page of a group's history. Without parameters it
+// returns the latest messages; otherwise one of
+//
+//	?before=<id>     messages older than a message
+//	?after=<id>      messages newer than a message
+//	?around=<id>     a message with the messages sent around it
+//	?date=<date>     messages from a day (2006-01-02, UTC) or time (RFC 3339) on
+//
+// and ?limit=. Polls are tallied for the viewer. A non-zero status means the
+// request was refused.
+func loadMessagePage(groupID int, viewerID int, q url.Values) (*MessagePage, int, string) {
+	limit := defaultMessagePage
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, http.StatusBadRequest, "invalid limit"
+		}
+		limit = min(n, maxMessagePage)
+	}
+
+	mode := ""
+	for _, key := range []string{"before", "after", "around", "date"} {
+		if q.Get(key) == "" {
+			continue
+		}
+		if mode != "" {
+			return nil, http.StatusBadRequest, "use only one of before, after, around and date"
+		}
+		mode = key
+	}
+
+	var cursor db.MessageCursor
+	switch mode {
+	case "before", "after", "around":
+		id, err := strconv.ParseInt(q.Get(mode), 10, 64)
+		if err != nil {
+			return nil, http.StatusBadRequest, "invalid " + mode + " cursor"
+		}
+		cursor, err = db.MessageCursorAt(groupID, id)
+		if err == sql.ErrNoRows {
+			return nil, http.StatusBadRequest, "unknown " + mode + " cursor"
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, "db error"
+		}
+	case "date":
+		t, _, err := parseTimeParam(q.Get("date"))
+		if err != nil {
+			return nil, http.StatusBadRequest, "invalid date, use 2006-01-02 or RFC 3339"
+		}
+		cursor = db.MessageCursorFrom(t)
+	}
+
+	// read first: replaying from here may repeat messages of the page, but
+	// can't skip any
+	seq, err := db.GroupSeq(groupID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "db error"
+	}
+
+	var msgs []models.Message
+	var older, newer bool
+	switch mode {
+	case "":
+		msgs, older, err = db.GetMessagesBefore(groupID, nil, limit)
+	case "before":
+		msgs, older, err = db.GetMessagesBefore(groupID, &cursor, limit)
+		newer = true
+	case "after":
+		msgs, newer, err = db.GetMessagesAfter(groupID, cursor, limit)
+		older = true
+	case "around":
+		var before []models.Message
+		before, older, err = db.GetMessagesBefore(groupID, &cursor, limit/2)
+		if err == nil {
+			// starts right before the message so it is included
+			from := db.MessageCursor{CreatedAt: cursor.CreatedAt, ID: cursor.ID - 1}
+			msgs, newer, err = db.GetMessagesAfter(groupID, from, limit-limit/2)
+			msgs = append(before, msgs...)
+		}
+	case "date":
+		msgs, newer, err = db.GetMessagesAfter(groupID, cursor, limit)
+		if err == nil && len(msgs) == 0 {
+			// nothing that late, show the latest messages instead
+			msgs, older, err = db.GetMessagesBefore(groupID, nil, limit)
+		} else if err == nil {
+			older, err = db.HasMessagesBefore(groupID, cursor)
+		}
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "db error"
+	}
+
+	views, err := messageViews(msgs, viewerID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "db error"
+	}
+	page := &MessagePage{Messages: views, Seq: seq}
+	if len(msgs) > 0 {
+		if older {
+			page.PrevCursor = &msgs[0].ID
+		}
+		if newer {
+			page.NextCursor = &msgs[len(msgs)-1].ID
+		}
+	}
+	return page, 0, ""
+}
+
+// parseTimeParam reads a day (2006-01-02, UTC) or an RFC 3339 time from a
+// query parameter. dateOnly tells which one it was.
+func parseTimeParam(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", v)
+	return t, true, err
+}
+
+// quoteContent shortens the text of a quoted message
+func quoteContent(content string) string {
+	runes := []rune(content)
+	if len(runes) <= maxQuoteLength {
+		return content
+	}
+	return string(runes[:maxQuoteLength]) + "…"
+}
+
+// routeMessage loads the message of the route and makes sure it belongs to
+// the group in the route
+func routeMessage(w http.ResponseWriter, r *http.Request) (*models.Message, bool) {
+	vars := mux.Vars(r)
+	groupID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return nil, false
+	}
+	messageID, err := strconv.ParseInt(vars["messageId"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	m, err := db.GetMessage(messageID)
+	if err == sql.ErrNoRows || (err == nil && m.GroupID != groupID) {
+		http.Error(w, "message not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return m, true
+}
+
+// canModifyMessage reports whether a user may edit or delete a message: its
+// author or an admin of its group
+func canModifyMessage(m *models.Message, userID int) bool {
+	return m.SenderID == userID || IsGroupAdmin(m.GroupID, userID)
+}
+
+// EditMessage replaces the content of a text message or announcement. Polls,
+// code and math blocks are sent again instead.
+// Endpoint: PUT /api/groups/{id}/messages/{messageId}
+func EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if !canModifyMessage(m, userID) {
+		http.Error(w, "only the author or a group admin can edit this message", http.StatusForbidden)
+		return
+	}
+	if m.MessageType != models.MessageTypeText && m.MessageType != models.MessageTypeAnnouncement {
+		http.Error(w, "only text messages and announcements can be edited", http.StatusBadRequest)
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if _, _, reason := parseMessagePayload(m.MessageType, req.Content, nil); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	edited, err := db.EditMessage(m.ID, userID, req.Content)
+	if errors.Is(err, db.ErrMessageDeleted) {
+		http.Error(w, "message was deleted", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	// members newly mentioned by the edit are notified; announcements have
+	// no mentions since they notify everyone (see publishMessage)
+	if edited.MessageType != models.MessageTypeAnnouncement {
+		recordMentions(edited)
+	}
+
+	publish(ws.GroupChannel(edited.GroupID), ws.EventMessageEdited, ws.MessageEditedPayload{
+		ID:       edited.ID,
+		GroupID:  edited.GroupID,
+		Content:  edited.Content,
+		EditedBy: userID,
+		EditedAt: *edited.EditedAt,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edited)
+}
+
+// DeleteMessage soft deletes a message. It stays in the history as a
+// tombstone without content.
+// Endpoint: DELETE /api/groups/{id}/messages/{messageId}
+func DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if !canModifyMessage(m, userID) {
+		http.Error(w, "only the author or a group admin can delete this message", http.StatusForbidden)
+		return
+	}
+
+	deleted, err := db.DeleteMessage(m.ID, userID)
+	if errors.Is(err, db.ErrMessageDeleted) {
+		http.Error(w, "message was already deleted", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	publish(ws.GroupChannel(deleted.GroupID), ws.EventMessageDeleted, ws.MessageDeletedPayload{
+		ID:        deleted.ID,
+		GroupID:   deleted.GroupID,
+		DeletedBy: userID,
+		DeletedAt: *deleted.DeletedAt,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Message deleted"})
+}
+
+// GetMessageRevisions lists the earlier versions of an edited message
+// Endpoint: GET /api/groups/{id}/messages/{messageId}/revisions
+func GetMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if ok, reason := groupReadAccess(m.GroupID, userID); !ok {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+	if m.DeletedAt != nil {
+		http.Error(w, "message was deleted", http.StatusGone)
+		return
+	}
+
+	revisions, err := db.GetMessageRevisions(m.ID)
+	if err != nil {
+		http.Error(w, "failed to load revisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   m,
+		"revisions": revisions,
+	})
+}
+
+// GetMessageThread returns a message with every reply below it, oldest
+// first. Replies to replies are included; their reply_to_id tells where
+// they belong.
+// Endpoint: GET /api/groups/{id}/messages/{messageId}/thread
+func GetMessageThread(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if ok, reason := groupReadAccess(m.GroupID, userID); !ok {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	root, replies, err := db.GetThread(m.ID)
+	if err != nil {
+		http.Error(w, "failed to load thread", http.StatusInternalServerError)
+		return
+	}
+
+	views, err := messageViews(append([]models.Message{*root}, replies...), userID)
+	if err != nil {
+		http.Error(w, "failed to load thread", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": views[0],
+		"replies": views[1:],
+	})
+}
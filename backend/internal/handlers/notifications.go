@@ -7,16 +7,13 @@ import (
 	"strconv"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
 )
 
 // GetUserNotifications retrieves notifications for the authenticated user
 func GetUserNotifications(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	limit := 20
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
@@ -38,11 +35,7 @@ func GetUserNotifications(w http.ResponseWriter, r *http.Request) {
 
 // MarkNotificationAsRead marks a notification as read
 func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req struct {
 		NotificationID int `json:"notification_id"`
@@ -54,7 +47,7 @@ func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
 
 	// Verify notification belongs to user
 	var belongsToUser bool
-	err = db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM notifications WHERE id=$1 AND user_id=$2)`, req.NotificationID, userID).Scan(&belongsToUser)
+	err := db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM notifications WHERE id=$1 AND user_id=$2)`, req.NotificationID, userID).Scan(&belongsToUser)
 	if err != nil || !belongsToUser {
 		http.Error(w, "notification not found", http.StatusNotFound)
 		return
@@ -72,11 +65,7 @@ func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
 
 // GetUnreadNotificationCount returns unread notification count for user
 func GetUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	count, err := db.GetUnreadNotificationCount(userID)
 	if err != nil {
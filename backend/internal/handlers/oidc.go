@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"studybuddy/internal/db"
+	"studybuddy/internal/oidc"
+)
+
+// OIDC is the single sign-on provider; nil while SSO is not configured
+var OIDC *oidc.Provider
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcLoginTTL    = 10 * time.Minute // time to sign in at the provider
+	oidcCodeTTL     = 2 * time.Minute  // time for the frontend to exchange the login code
+)
+
+var (
+	errSSONoEmail    = errors.New("your identity provider did not share an email address")
+	errSSOEmailTaken = errors.New("an account with this email already exists; sign in with your password")
+)
+
+type OIDCExchangeRequest struct {
+	Code string `json:"code"`
+}
+
+// GetSSOProvider tells the frontend whether to show the SSO button
+// Endpoint: GET /api/auth/oidc
+func GetSSOProvider(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"enabled": OIDC != nil}
+	if OIDC != nil {
+		resp["name"] = OIDC.Name()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// StartOIDCLogin sends the browser to the identity provider
+// Endpoint: GET /api/auth/oidc/login
+func StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+
+	if err := db.CreateOIDCLogin(state, nonce, verifier, oidcLoginTTL); err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+	authURL, err := OIDC.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+
+	// binds the state to this browser so a callback URL can't be replayed
+	// in someone else's
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    db.HashToken(state),
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || !appConfig.IsDev(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback handles the redirect back from the provider. It resolves the
+// local account and sends the browser to the frontend with a one-time code.
+// Endpoint: GET /api/auth/oidc/callback
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		// the user cancelled or the provider refused
+		ssoFailed(w, r, fmt.Errorf("provider returned %s: %s", e, q.Get("error_description")))
+		return
+	}
+	state, code := q.Get("state"), q.Get("code")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || code == "" || err != nil || cookie.Value != db.HashToken(state) {
+		ssoFailed(w, r, errors.New("login state mismatch"))
+		return
+	}
+
+	loginID, nonce, verifier, err := db.ConsumeOIDCState(state)
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+
+	id, err := OIDC.Exchange(r.Context(), code, verifier, nonce)
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+
+	userID, err := resolveSSOUser(id)
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+
+	loginCode, err := oidc.RandomString()
+	if err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+	if err := db.SetOIDCLoginCode(loginID, userID, loginCode, oidcCodeTTL); err != nil {
+		ssoFailed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, appLink("/auth/sso", loginCode), http.StatusFound)
+}
+
+// ExchangeOIDCLogin trades the one-time code from OIDCCallback for tokens, or
+// for a 2FA challenge when the account has 2FA on
+// Endpoint: POST /api/auth/oidc/exchange
+func ExchangeOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req OIDCExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := db.ConsumeOIDCLoginCode(req.Code)
+	if err != nil {
+		if errors.Is(err, db.ErrOIDCLoginInvalid) {
+			http.Error(w, "Invalid or expired login code", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	if err := db.DB.QueryRow(`SELECT LOWER(email) FROM users WHERE id=$1`, userID).Scan(&email); err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	loginOrChallenge(w, r, userID, email)
+}
+
+// resolveSSOUser finds the account of an external identity. Unknown
+// identities are linked to the account with the same address when the
+// provider vouches for it, otherwise a new account is created.
+func resolveSSOUser(id *oidc.Identity) (int, error) {
+	university := ""
+	if appConfig.OIDC.UniversityClaim != "" {
+		university = id.Claim(appConfig.OIDC.UniversityClaim)
+	}
+
+	userID, err := db.FindIdentityUser(id.Issuer, id.Subject)
+	if err != nil {
+		return 0, err
+	}
+	if userID == 0 {
+		if id.Email == "" {
+			return 0, errSSONoEmail
+		}
+
+		var existingID int
+		db.DB.QueryRow(`SELECT id FROM users WHERE LOWER(email) = $1`, id.Email).Scan(&existingID)
+		if existingID != 0 {
+			if !id.EmailVerified {
+				return 0, errSSOEmailTaken
+			}
+			if err := db.LinkIdentity(existingID, id.Issuer, id.Subject, id.Email); err != nil {
+				return 0, err
+			}
+			userID = existingID
+		} else {
+			username, err := ssoUsername(id)
+			if err != nil {
+				return 0, err
+			}
+			return db.CreateSSOUser(username, id.Email, university, id.EmailVerified, id.Issuer, id.Subject)
+		}
+	}
+
+	// fill in the university for accounts that don't have one yet
+	if university != "" {
+		if _, err := db.DB.Exec(`UPDATE users SET university=$2 WHERE id=$1 AND COALESCE(university, '') = ''`, userID, university); err != nil {
+			fmt.Printf("Failed to set university for user %d: %v\n", userID, err)
+		}
+	}
+	return userID, nil
+}
+
+// ssoUsername picks a free username from the preferred username, the email's
+// local part or the display name
+func ssoUsername(id *oidc.Identity) (string, error) {
+	base := ""
+	local, _, _ := strings.Cut(id.Email, "@")
+	for _, candidate := range []string{id.Username, local, id.Name} {
+		if base = sanitizeUsername(candidate); base != "" {
+			break
+		}
+	}
+	if base == "" {
+		base = "student"
+	}
+
+	for i := 1; i <= 50; i++ {
+		name := base
+		if i > 1 {
+			name = base + strconv.Itoa(i)
+		}
+		taken, err := db.UsernameTaken(name)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+	}
+	suffix, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	return base + "-" + strings.ToLower(suffix[:6]), nil
+}
+
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('.')
+		}
+		if b.Len() >= 30 {
+			break
+		}
+	}
+	return strings.Trim(b.String(), ".-_")
+}
+
+// ssoFailed sends the browser back to the frontend login page with an error
+func ssoFailed(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Printf("SSO login failed: %v\n", err)
+	msg := "Single sign-on failed, please try again"
+	if errors.Is(err, errSSONoEmail) || errors.Is(err, errSSOEmailTaken) {
+		msg = err.Error()
+	}
+	http.Redirect(w, r, appConfig.AppURL+"/login?sso_error="+url.QueryEscape(msg), http.StatusFound)
+}
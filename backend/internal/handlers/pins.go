@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/models"
+	"studybuddy/internal/ws"
+
+	"github.com/gorilla/mux"
+)
+
+// maxPinnedMessages is how many messages a group can have pinned at once
+const maxPinnedMessages = 10
+
+// PinView is a pinned message
+type PinView struct {
+	PinnedBy     int         `json:"pinned_by"`
+	PinnedByName string      `json:"pinned_by_name"`
+	PinnedAt     string      `json:"pinned_at"`
+	Message      MessageView `json:"message"`
+}
+
+type MuteGroupRequest struct {
+	Muted bool `json:"muted"`
+}
+
+// PinMessage pins a message to the top of a group's chat. Only group admins
+// can pin, and only maxPinnedMessages messages at a time.
+// Endpoint: POST /api/groups/{id}/messages/{messageId}/pin
+func PinMessage(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if !IsGroupAdmin(m.GroupID, userID) {
+		http.Error(w, "only group admins can pin messages", http.StatusForbidden)
+		return
+	}
+	if m.DeletedAt != nil {
+		http.Error(w, "message was deleted", http.StatusGone)
+		return
+	}
+
+	pin, pinned, err := db.PinMessage(m.GroupID, m.ID, userID, maxPinnedMessages)
+	if errors.Is(err, db.ErrPinLimit) {
+		http.Error(w, "a group can have at most "+strconv.Itoa(maxPinnedMessages)+" pinned messages, unpin one first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to pin message", http.StatusInternalServerError)
+		return
+	}
+	if pinned {
+		publish(ws.GroupChannel(m.GroupID), ws.EventMessagePinned, ws.PinPayload{
+			MessageID: m.ID,
+			GroupID:   m.GroupID,
+			UserID:    userID,
+			At:        pin.PinnedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id":     m.ID,
+		"pinned_by":      pin.PinnedBy,
+		"pinned_by_name": pin.PinnedByName,
+		"pinned_at":      pin.PinnedAt.Format(time.RFC3339),
+	})
+}
+
+// UnpinMessage unpins a message. Only group admins can unpin.
+// Endpoint: DELETE /api/groups/{id}/messages/{messageId}/pin
+func UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if !IsGroupAdmin(m.GroupID, userID) {
+		http.Error(w, "only group admins can unpin messages", http.StatusForbidden)
+		return
+	}
+
+	unpinned, err := db.UnpinMessage(m.ID)
+	if err != nil {
+		http.Error(w, "failed to unpin message", http.StatusInternalServerError)
+		return
+	}
+	if !unpinned {
+		http.Error(w, "message is not pinned", http.StatusNotFound)
+		return
+	}
+	publish(ws.GroupChannel(m.GroupID), ws.EventMessageUnpinned, ws.PinPayload{
+		MessageID: m.ID,
+		GroupID:   m.GroupID,
+		UserID:    userID,
+		At:        time.Now().UTC(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Message unpinned"})
+}
+
+// GetGroupPins lists a group's pinned messages, most recently pinned first.
+// Deleted messages drop off the list.
+// Endpoint: GET /api/groups/{id}/pins
+func GetGroupPins(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+	if ok, reason := groupReadAccess(groupID, userID); !ok {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	pins, err := db.GetPins(groupID)
+	if err != nil {
+		http.Error(w, "failed to load pins", http.StatusInternalServerError)
+		return
+	}
+	ids := make([]int64, len(pins))
+	for i, p := range pins {
+		ids[i] = p.MessageID
+	}
+	msgs, err := db.GetMessagesByID(ids)
+	if err != nil {
+		http.Error(w, "failed to load pins", http.StatusInternalServerError)
+		return
+	}
+	views, err := messageViews(msgs, userID)
+	if err != nil {
+		http.Error(w, "failed to load pins", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[int64]MessageView, len(views))
+	for _, v := range views {
+		byID[v.ID] = v
+	}
+
+	res := make([]PinView, 0, len(pins))
+	for _, p := range pins {
+		if v, ok := byID[p.MessageID]; ok {
+			res = append(res, PinView{
+				PinnedBy:     p.PinnedBy,
+				PinnedByName: p.PinnedByName,
+				PinnedAt:     p.PinnedAt.Format(time.RFC3339),
+				Message:      v,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// MuteGroup mutes or unmutes a group for the user: a muted group sends no
+// mention or reply notifications. Announcements are always sent.
+// Endpoint: PUT /api/groups/{id}/mute
+func MuteGroup(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+
+	var req MuteGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	member, err := db.SetGroupMuted(groupID, userID, req.Muted)
+	if err != nil {
+		http.Error(w, "failed to update group", http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		http.Error(w, "you are not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"group_id": groupID, "muted": req.Muted})
+}
+
+// notifyAnnouncement sends an announcement to every member of its group but
+// its author, whether they muted the group or not
+func notifyAnnouncement(m *models.Message) {
+	var groupName string
+	if err := db.DB.QueryRow(`SELECT name FROM groups WHERE id=$1`, m.GroupID).Scan(&groupName); err != nil {
+		groupName = "Group"
+	}
+	members, err := db.GroupMemberIDs(m.GroupID, false)
+	if err != nil {
+		fmt.Printf("Failed to load members of group %d: %v\n", m.GroupID, err)
+		return
+	}
+	for _, memberID := range members {
+		if memberID != m.SenderID {
+			db.CreateNotification(memberID, "announcement", "Announcement in "+groupName,
+				m.SenderName+": "+messagePreview(m), &m.GroupID, nil, nil)
+		}
+	}
+}
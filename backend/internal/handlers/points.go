@@ -5,18 +5,16 @@ import (
 	"net/http"
 	"strconv"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
+	"studybuddy/internal/ws"
 
 	"github.com/gorilla/mux"
 )
 
 // GetUserStats returns user's points, rank, and leaderboard position
 func GetUserStats(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Get user rank info
 	rank, err := db.GetUserRank(userID)
@@ -113,11 +111,7 @@ func AddMessageReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req struct {
 		MessageID    int    `json:"message_id"`
@@ -164,6 +158,16 @@ func AddMessageReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var groupID int
+	if err := db.DB.QueryRow(`SELECT group_id FROM messages WHERE id = $1`, req.MessageID).Scan(&groupID); err == nil {
+		publish(ws.GroupChannel(groupID), ws.EventReactionAdded, ws.ReactionPayload{
+			MessageID:    req.MessageID,
+			GroupID:      groupID,
+			UserID:       userID,
+			ReactionType: req.ReactionType,
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "success",
@@ -173,11 +177,7 @@ func AddMessageReaction(w http.ResponseWriter, r *http.Request) {
 
 // GetUserPointsHistory returns point transaction history
 func GetUserPointsHistory(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	limitStr := r.URL.Query().Get("limit")
 	limit := 20
@@ -231,15 +231,11 @@ func GetUserPointsHistory(w http.ResponseWriter, r *http.Request) {
 
 // GetUserActivityStats returns study hours, sessions attended, and resources shared
 func GetUserActivityStats(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Count scheduled group sessions the user is attending
 	var sessionsAttended int
-	err = db.DB.QueryRow(`
+	err := db.DB.QueryRow(`
 		SELECT COUNT(*) FROM scheduled_group_sessions sgs
 		INNER JOIN group_members gm ON gm.group_id = sgs.group_id
 		WHERE gm.user_id = $1 AND sgs.start_time <= NOW()
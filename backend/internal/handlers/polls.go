@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/models"
+	"studybuddy/internal/ws"
+)
+
+type VotePollRequest struct {
+	Options []int `json:"options"` // option indexes; empty retracts the vote
+}
+
+// VotePoll replaces the user's vote on a poll and pushes the new tally to
+// the group. Single choice polls take one option.
+// Endpoint: POST /api/groups/{id}/messages/{messageId}/vote
+func VotePoll(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if !IsGroupMember(m.GroupID, userID) {
+		http.Error(w, "you are not a member of this group", http.StatusForbidden)
+		return
+	}
+	if m.MessageType != models.MessageTypePoll {
+		http.Error(w, "message is not a poll", http.StatusBadRequest)
+		return
+	}
+	if m.DeletedAt != nil {
+		http.Error(w, "poll was deleted", http.StatusGone)
+		return
+	}
+
+	var poll models.PollPayload
+	if err := json.Unmarshal(m.Payload, &poll); err != nil {
+		http.Error(w, "invalid poll", http.StatusInternalServerError)
+		return
+	}
+	if poll.ClosesAt != nil && !time.Now().Before(*poll.ClosesAt) {
+		http.Error(w, "poll is closed", http.StatusConflict)
+		return
+	}
+
+	var req VotePollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Options) > 1 && !poll.MultipleChoice {
+		http.Error(w, "this poll takes one option", http.StatusBadRequest)
+		return
+	}
+	chosen := make(map[int]bool, len(req.Options))
+	for _, option := range req.Options {
+		if option < 0 || option >= len(poll.Options) {
+			http.Error(w, "unknown poll option", http.StatusBadRequest)
+			return
+		}
+		if chosen[option] {
+			http.Error(w, "options must differ", http.StatusBadRequest)
+			return
+		}
+		chosen[option] = true
+	}
+
+	if err := db.SetPollVotes(m.ID, userID, req.Options); err != nil {
+		http.Error(w, "failed to save vote", http.StatusInternalServerError)
+		return
+	}
+	results, err := db.GetPollResults(map[int64]int{m.ID: len(poll.Options)}, userID)
+	if err != nil {
+		http.Error(w, "failed to tally votes", http.StatusInternalServerError)
+		return
+	}
+	res := results[m.ID]
+
+	publish(ws.GroupChannel(m.GroupID), ws.EventPollUpdated, ws.PollPayload{
+		MessageID: m.ID,
+		GroupID:   m.GroupID,
+		Counts:    res.Counts,
+		Voters:    res.Voters,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
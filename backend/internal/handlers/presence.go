@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"studybuddy/internal/db"
+	"studybuddy/internal/ws"
+)
+
+// typingInterval throttles typing events: a connection sends at most one
+// "started typing" per group in this interval. Clients should hide the
+// indicator when it isn't refreshed for about twice as long.
+const typingInterval = 3 * time.Second
+
+// Each replica refreshes its presence rows every presenceHeartbeat; rows not
+// refreshed for presenceTTL belong to a replica that stopped and expire
+const (
+	presenceHeartbeat = 30 * time.Second
+	presenceTTL       = 3 * presenceHeartbeat
+)
+
+// visiblePresence applies a user's privacy flags to their presence as seen by
+// others
+func visiblePresence(online bool, lastSeen *time.Time, showOnline bool, showLastSeen bool) (bool, *time.Time) {
+	if !showOnline {
+		online = false
+	}
+	if !showLastSeen {
+		lastSeen = nil
+	}
+	return online, lastSeen
+}
+
+// PresenceChanged records a user coming online or going away on this replica
+// and tells their groups when their presence changes (hooked into
+// GlobalHub.OnPresence in main.go). Users who hide their online status
+// always look offline, so nothing is sent for them.
+func PresenceChanged(userID int, online bool) {
+	p, err := db.UpdatePresence(userID, online, presenceTTL)
+	if err != nil {
+		fmt.Printf("Failed to update presence of user %d: %v\n", userID, err)
+		return
+	}
+	broadcastPresence(p)
+}
+
+// RunPresenceHeartbeat keeps this replica's presence rows fresh and expires
+// those of replicas that stopped, telling their users' groups that they went
+// offline. It runs until the process exits.
+func RunPresenceHeartbeat() {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := db.HeartbeatPresence(); err != nil {
+			fmt.Printf("Failed to refresh presence: %v\n", err)
+		}
+		offline, err := db.ExpirePresence(presenceTTL)
+		if err != nil {
+			fmt.Printf("Failed to expire presence: %v\n", err)
+			continue
+		}
+		for i := range offline {
+			broadcastPresence(&offline[i])
+		}
+	}
+}
+
+// broadcastPresence tells a user's groups about a change of their presence
+func broadcastPresence(p *db.Presence) {
+	if p.Online == p.WasOnline || !p.ShowOnline {
+		return
+	}
+
+	status := "offline"
+	if p.Online {
+		status = "online"
+	}
+	_, lastSeen := visiblePresence(p.Online, p.LastSeen, p.ShowOnline, p.ShowLastSeen)
+
+	rows, err := db.DB.Query(`SELECT group_id FROM group_members WHERE user_id=$1`, p.UserID)
+	if err != nil {
+		fmt.Printf("Failed to load groups of user %d: %v\n", p.UserID, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var groupID int
+		if err := rows.Scan(&groupID); err != nil {
+			continue
+		}
+		publish(ws.GroupChannel(groupID), ws.EventPresence, ws.PresencePayload{
+			GroupID:  groupID,
+			UserID:   p.UserID,
+			Status:   status,
+			LastSeen: lastSeen,
+		})
+	}
+}
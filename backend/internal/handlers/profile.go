@@ -11,7 +11,9 @@ import (
 	"strings"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
+	"studybuddy/internal/mail"
 	"studybuddy/internal/models"
 
 	"github.com/gorilla/mux"
@@ -34,11 +36,13 @@ type UpdateProfileRequest struct {
 	ShowLocation         *bool   `json:"show_location,omitempty"`
 	ShowUniversity       *bool   `json:"show_university,omitempty"`
 	ShowBio              *bool   `json:"show_bio,omitempty"`
+	NotifyNewSignin      *bool   `json:"notify_new_signin,omitempty"`
 }
 
 type ChangeEmailRequest struct {
 	NewEmail string `json:"new_email"`
 	Password string `json:"password"`
+	TOTPCode string `json:"totp_code,omitempty"` // required when 2FA is on
 }
 
 type ChangePhoneRequest struct {
@@ -48,26 +52,24 @@ type ChangePhoneRequest struct {
 
 type DeleteAccountRequest struct {
 	Password string `json:"password"`
+	TOTPCode string `json:"totp_code,omitempty"` // required when 2FA is on
 }
 
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password"`
 	NewPassword     string `json:"new_password"`
+	TOTPCode        string `json:"totp_code,omitempty"` // required when 2FA is on
 }
 
 func GetProfile(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var user models.User
-	err = db.DB.QueryRow(`
-		SELECT id, username, email, profile_pic, bio, phone, location, university, major, last_seen, is_online, show_last_seen, show_online, notifications_enabled, show_email, show_phone, show_location, show_university, show_bio, created_at
+	err := db.DB.QueryRow(`
+		SELECT id, username, email, profile_pic, bio, phone, location, university, major, last_seen, is_online, show_last_seen, show_online, notifications_enabled, show_email, show_phone, show_location, show_university, show_bio, created_at, email_verified_at IS NOT NULL, COALESCE(notify_new_signin, TRUE)
 		FROM users WHERE id=$1`, userID).Scan(
 		&user.ID, &user.Username, &user.Email, &user.ProfilePic, &user.Bio, &user.Phone, &user.Location, &user.University, &user.Major, &user.LastSeen,
-		&user.IsOnline, &user.ShowLastSeen, &user.ShowOnline, &user.NotificationsEnabled, &user.ShowEmail, &user.ShowPhone, &user.ShowLocation, &user.ShowUniversity, &user.ShowBio, &user.CreatedAt)
+		&user.IsOnline, &user.ShowLastSeen, &user.ShowOnline, &user.NotificationsEnabled, &user.ShowEmail, &user.ShowPhone, &user.ShowLocation, &user.ShowUniversity, &user.ShowBio, &user.CreatedAt, &user.EmailVerified, &user.NotifyNewSignin)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
@@ -88,7 +90,7 @@ func GetPublicProfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if the requesting user is viewing their own profile
-	authUserID, _ := GetUserIDFromRequest(r)
+	authUserID := auth.UserID(r.Context())
 	isOwnProfile := authUserID == userID
 
 	var user models.User
@@ -121,6 +123,7 @@ func GetPublicProfile(w http.ResponseWriter, r *http.Request) {
 		if !user.ShowBio {
 			user.Bio = nil
 		}
+		user.IsOnline, user.LastSeen = visiblePresence(user.IsOnline, user.LastSeen, user.ShowOnline, user.ShowLastSeen)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -128,11 +131,7 @@ func GetPublicProfile(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateProfile(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req UpdateProfileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -220,11 +219,16 @@ func UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		args = append(args, *req.ShowBio)
 		argCount++
 	}
+	if req.NotifyNewSignin != nil {
+		setParts = append(setParts, "notify_new_signin=$"+strconv.Itoa(argCount))
+		args = append(args, *req.NotifyNewSignin)
+		argCount++
+	}
 
 	query := "UPDATE users SET " + strings.Join(setParts, ", ") + " WHERE id=$" + strconv.Itoa(argCount)
 	args = append(args, userID)
 
-	_, err = db.DB.Exec(query, args...)
+	_, err := db.DB.Exec(query, args...)
 	if err != nil {
 		http.Error(w, "Update failed", http.StatusInternalServerError)
 		return
@@ -235,11 +239,7 @@ func UpdateProfile(w http.ResponseWriter, r *http.Request) {
 }
 
 func ChangeEmail(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req ChangeEmailRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -249,29 +249,40 @@ func ChangeEmail(w http.ResponseWriter, r *http.Request) {
 
 	// Verify password
 	var hashedPassword string
-	err = db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
+	err := db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
 		http.Error(w, "Invalid password", http.StatusUnauthorized)
 		return
 	}
+	if !requireSecondFactor(w, userID, req.TOTPCode) {
+		return
+	}
 
-	// Update email
-	_, err = db.DB.Exec(`UPDATE users SET email=$1 WHERE id=$2`, req.NewEmail, userID)
-	if err != nil {
-		http.Error(w, "Email update failed", http.StatusInternalServerError)
+	newEmail := strings.ToLower(strings.TrimSpace(req.NewEmail))
+	if !mail.ValidAddress(newEmail) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	var taken bool
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1)`, newEmail).Scan(&taken)
+	if taken {
+		http.Error(w, "Email already in use", http.StatusConflict)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message":"Email changed"}`))
+	// The address only changes once the link sent to it is opened, see ConfirmEmailChange
+	if err := sendEmailChangeConfirmation(userID, newEmail); err != nil {
+		fmt.Printf("Failed to send email change confirmation for user %d: %v\n", userID, err)
+		http.Error(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"message":"Confirmation sent to the new address"}`))
 }
 
 func ChangePhone(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req ChangePhoneRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -281,7 +292,7 @@ func ChangePhone(w http.ResponseWriter, r *http.Request) {
 
 	// Verify password
 	var hashedPassword string
-	err = db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
+	err := db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
 		http.Error(w, "Invalid password", http.StatusUnauthorized)
 		return
@@ -299,11 +310,7 @@ func ChangePhone(w http.ResponseWriter, r *http.Request) {
 }
 
 func DeleteAccount(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req DeleteAccountRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -313,11 +320,14 @@ func DeleteAccount(w http.ResponseWriter, r *http.Request) {
 
 	// Verify password
 	var hashedPassword string
-	err = db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
+	err := db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
 		http.Error(w, "Invalid password", http.StatusUnauthorized)
 		return
 	}
+	if !requireSecondFactor(w, userID, req.TOTPCode) {
+		return
+	}
 
 	// Delete user (cascade will handle signin_logs)
 	_, err = db.DB.Exec(`DELETE FROM users WHERE id=$1`, userID)
@@ -332,14 +342,11 @@ func DeleteAccount(w http.ResponseWriter, r *http.Request) {
 
 // UploadProfilePhoto handles profile photo uploads
 func UploadProfilePhoto(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Parse multipart form
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB limit
+	r.Body = http.MaxBytesReader(w, r.Body, appConfig.Uploads.MaxProfilePhotoBytes)
+	if err := r.ParseMultipartForm(appConfig.Uploads.MaxProfilePhotoBytes); err != nil {
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
 		return
 	}
@@ -400,11 +407,8 @@ func UploadProfilePhoto(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 func ChangePassword(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
+	sessionID := auth.SessionID(r.Context())
 
 	var req ChangePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -430,7 +434,7 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 
 	// Get current password hash
 	var hashedPassword string
-	err = db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
+	err := db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
@@ -441,6 +445,9 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
 		return
 	}
+	if !requireSecondFactor(w, userID, req.TOTPCode) {
+		return
+	}
 
 	// Hash new password
 	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
@@ -456,6 +463,11 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Sign out every other device; this one stays logged in
+	if _, err := db.RevokeUserSessions(userID, sessionID); err != nil {
+		fmt.Printf("Failed to revoke sessions for user %d: %v\n", userID, err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
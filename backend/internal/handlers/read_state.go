@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/ws"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSeenByMembers is the largest group that shares read receipts; in
+// bigger groups they would mostly be noise
+const maxSeenByMembers = 30
+
+type MarkReadRequest struct {
+	MessageID int64 `json:"message_id,omitempty"` // latest message when left out
+}
+
+func groupMemberCount(groupID int) int {
+	var n int
+	db.DB.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id=$1`, groupID).Scan(&n)
+	return n
+}
+
+// markRead moves a member's read position in a group forward to a message (0
+// for the latest one). When it moves, the user's other connections get the
+// new unread count and, in small groups, the other members see the receipt.
+// The returned reason is meant for the user.
+func markRead(groupID int, userID int, messageID int64) (*ws.ReadStatePayload, string, error) {
+	if messageID == 0 {
+		latest, err := db.LatestMessageID(groupID)
+		if err != nil {
+			return nil, "", err
+		}
+		messageID = latest
+	} else {
+		m, err := db.GetMessage(messageID)
+		if err == sql.ErrNoRows || (err == nil && m.GroupID != groupID) {
+			return nil, "message not found", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	lastRead, advanced, err := db.MarkRead(groupID, userID, messageID)
+	if err != nil {
+		return nil, "", err
+	}
+	unread, err := db.UnreadCount(groupID, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	state := &ws.ReadStatePayload{GroupID: groupID, LastReadMessageID: lastRead, UnreadCount: unread}
+	if advanced {
+		publish(ws.UserChannel(userID), ws.EventReadStateUpdated, state)
+
+		if groupMemberCount(groupID) <= maxSeenByMembers {
+			var username string
+			db.DB.QueryRow(`SELECT COALESCE(NULLIF(username, ''), email, '') FROM users WHERE id=$1`, userID).Scan(&username)
+			publish(ws.GroupChannel(groupID), ws.EventMessageRead, ws.MessageReadPayload{
+				GroupID:           groupID,
+				UserID:            userID,
+				Username:          username,
+				LastReadMessageID: lastRead,
+			})
+		}
+	}
+	return state, "", nil
+}
+
+// MarkGroupRead moves the user's read position in a group forward
+// Endpoint: POST /api/groups/{id}/read
+func MarkGroupRead(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+	if !IsGroupMember(groupID, userID) {
+		http.Error(w, "you are not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	var req MarkReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	state, reason, err := markRead(groupID, userID, req.MessageID)
+	if err != nil {
+		http.Error(w, "failed to update read state", http.StatusInternalServerError)
+		return
+	}
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// GetMessageSeenBy lists the members who have read a message. Only groups of
+// up to maxSeenByMembers members share read receipts.
+// Endpoint: GET /api/groups/{id}/messages/{messageId}/seen
+func GetMessageSeenBy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	m, ok := routeMessage(w, r)
+	if !ok {
+		return
+	}
+	if !IsGroupMember(m.GroupID, userID) {
+		http.Error(w, "you are not a member of this group", http.StatusForbidden)
+		return
+	}
+	if groupMemberCount(m.GroupID) > maxSeenByMembers {
+		http.Error(w, "read receipts are only shared in groups of up to "+strconv.Itoa(maxSeenByMembers)+" members", http.StatusForbidden)
+		return
+	}
+
+	seen, err := db.GetSeenBy(m.GroupID, m.ID, m.SenderID)
+	if err != nil {
+		http.Error(w, "failed to load read receipts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": m.ID,
+		"seen_by":    seen,
+	})
+}
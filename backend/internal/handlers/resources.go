@@ -11,17 +11,15 @@ import (
 	"strings"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
+	"studybuddy/internal/ws"
 	"github.com/gorilla/mux"
 )
 
 // UploadGroupResource handles uploading a resource to a group
 func UploadGroupResource(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	groupIDStr := vars["id"]
@@ -43,7 +41,8 @@ func UploadGroupResource(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse the multipart form
-	err = r.ParseMultipartForm(100 * 1024 * 1024) // 100 MB max
+	r.Body = http.MaxBytesReader(w, r.Body, appConfig.Uploads.MaxResourceBytes)
+	err = r.ParseMultipartForm(appConfig.Uploads.MaxResourceBytes)
 	if err != nil {
 		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
 		return
@@ -57,7 +56,7 @@ func UploadGroupResource(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Validate file size
-	if handler.Size > 100*1024*1024 { // 100 MB
+	if handler.Size > appConfig.Uploads.MaxResourceBytes {
 		http.Error(w, "File too large", http.StatusBadRequest)
 		return
 	}
@@ -97,6 +96,7 @@ func UploadGroupResource(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to save resource: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	publishResource(resourceID, groupID, userID, handler.Filename, "/"+filepath, handler.Size, handler.Header.Get("Content-Type"))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -106,6 +106,19 @@ func UploadGroupResource(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// publishResource tells the group's open connections about a new resource
+func publishResource(id int, groupID int, uploadedBy int, filename string, url string, size int64, mimeType string) {
+	publish(ws.GroupChannel(groupID), ws.EventResourceUploaded, ws.ResourcePayload{
+		ID:         id,
+		GroupID:    groupID,
+		UploadedBy: uploadedBy,
+		Filename:   filename,
+		URL:        url,
+		Size:       size,
+		MimeType:   mimeType,
+	})
+}
+
 // GetGroupResources retrieves all resources for a group
 func GetGroupResources(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -161,11 +174,7 @@ func DownloadGroupResource(w http.ResponseWriter, r *http.Request) {
 
 // DeleteGroupResource deletes a resource from a group
 func DeleteGroupResource(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	resourceIDStr := vars["resourceId"]
@@ -8,17 +8,15 @@ import (
 	"strconv"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
+	"studybuddy/internal/ws"
 	"github.com/gorilla/mux"
 )
 
 // CreateGroupSession handles creating a new scheduled session for a group
 func CreateGroupSession(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	groupIDStr := vars["id"]
@@ -101,6 +99,17 @@ func CreateGroupSession(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	publish(ws.GroupChannel(groupID), ws.EventSessionCreated, ws.SessionPayload{
+		ID:              sessionID,
+		GroupID:         groupID,
+		CreatedBy:       userID,
+		Title:           req.Title,
+		Description:     req.Description,
+		ScheduledTime:   req.ScheduledTime,
+		DurationMinutes: req.DurationMinutes,
+		VotingEnabled:   req.VotingEnabled,
+	})
+
 	// Create notifications for all group members
 	rows, err := db.DB.Query(`SELECT DISTINCT user_id FROM group_members WHERE group_id=$1`, groupID)
 	if err == nil {
@@ -131,11 +140,7 @@ func CreateGroupSession(w http.ResponseWriter, r *http.Request) {
 
 // GetGroupSessions retrieves all sessions for a group
 func GetGroupSessions(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	groupIDStr := vars["id"]
@@ -168,11 +173,7 @@ func GetGroupSessions(w http.ResponseWriter, r *http.Request) {
 
 // GetGroupSession retrieves a single session by ID
 func GetGroupSession(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	sessionIDStr := vars["id"]
@@ -204,11 +205,7 @@ func GetGroupSession(w http.ResponseWriter, r *http.Request) {
 
 // JoinSession marks a user as attending a session
 func JoinSession(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	sessionIDStr := vars["id"]
@@ -247,11 +244,7 @@ func JoinSession(w http.ResponseWriter, r *http.Request) {
 
 // VoteForSessionTime records a user's vote for a session time option
 func VoteForSessionTime(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	sessionIDStr := vars["id"]
@@ -325,11 +318,7 @@ func GetSessionAttendees(w http.ResponseWriter, r *http.Request) {
 
 // DeleteGroupSession handles deleting a scheduled session
 func DeleteGroupSession(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	vars := mux.Vars(r)
 	sessionIDStr := vars["id"]
@@ -372,12 +361,7 @@ func DeleteGroupSession(w http.ResponseWriter, r *http.Request) {
 }
 // GetUserUpcomingSessions retrieves all upcoming sessions for the authenticated user across all groups
 func GetUserUpcomingSessions(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		fmt.Printf("Unauthorized: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	fmt.Printf("Fetching upcoming sessions for user %d\n", userID)
 
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+
+	"github.com/gorilla/mux"
+)
+
+// Search page sizes (?limit=)
+const (
+	defaultSearchPage = 20
+	maxSearchPage     = 50
+	maxSearchQuery    = 200
+)
+
+// searchOptions reads the search parameters shared by both search
+// endpoints:
+//
+//	?q=         the search, in web search syntax ("exact phrase", or, -word)
+//	?sender=    user id of the sender or uploader
+//	?from=&to=  a date range; days (2006-01-02) or RFC 3339 times, to is inclusive for days
+//	?type=      a message type (text, file, ...) or resource for shared files only
+//	?limit=&offset=
+//
+// The returned reason is meant for the user.
+func searchOptions(r *http.Request) (db.SearchOptions, string) {
+	q := r.URL.Query()
+	o := db.SearchOptions{
+		Query:    strings.TrimSpace(q.Get("q")),
+		ViewerID: auth.UserID(r.Context()),
+		Type:     q.Get("type"),
+		Limit:    defaultSearchPage,
+	}
+	if o.Query == "" {
+		return o, "q required"
+	}
+	if len(o.Query) > maxSearchQuery {
+		return o, "search is too long"
+	}
+
+	if v := q.Get("sender"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return o, "invalid sender"
+		}
+		o.SenderID = id
+	}
+	if v := q.Get("from"); v != "" {
+		t, _, err := parseTimeParam(v)
+		if err != nil {
+			return o, "invalid from, use 2006-01-02 or RFC 3339"
+		}
+		o.From = &t
+	}
+	if v := q.Get("to"); v != "" {
+		t, dateOnly, err := parseTimeParam(v)
+		if err != nil {
+			return o, "invalid to, use 2006-01-02 or RFC 3339"
+		}
+		// a day includes everything sent that day
+		if dateOnly {
+			t = t.Add(24 * time.Hour)
+		}
+		o.To = &t
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return o, "invalid limit"
+		}
+		o.Limit = min(n, maxSearchPage)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, "invalid offset"
+		}
+		o.Offset = n
+	}
+	return o, ""
+}
+
+// writeSearchResults runs a search and writes one page of results.
+// next_offset is null on the last page.
+func writeSearchResults(w http.ResponseWriter, o db.SearchOptions) {
+	limit := o.Limit
+	o.Limit++ // one more tells whether there is a next page
+	results, err := db.Search(o)
+	if err != nil {
+		http.Error(w, "search failed", http.StatusInternalServerError)
+		return
+	}
+
+	var next *int
+	if len(results) > limit {
+		results = results[:limit]
+		n := o.Offset + limit
+		next = &n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":     results,
+		"next_offset": next,
+	})
+}
+
+// SearchGroup searches the messages and shared files of one group
+// Endpoint: GET /api/groups/{id}/search
+func SearchGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+
+	o, reason := searchOptions(r)
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+	if ok, reason := groupReadAccess(groupID, o.ViewerID); !ok {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+	o.GroupID = groupID
+
+	writeSearchResults(w, o)
+}
+
+// Search searches every group the user can view: the groups they are a
+// member of and those that allow viewing content without joining
+// Endpoint: GET /api/search
+func Search(w http.ResponseWriter, r *http.Request) {
+	o, reason := searchOptions(r)
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
+	writeSearchResults(w, o)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+
+	"github.com/gorilla/mux"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new access token. The refresh
+// token is rotated on every use; presenting an old one revokes the session.
+// Endpoint: POST /api/token/refresh
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token required", http.StatusBadRequest)
+		return
+	}
+
+	newToken, newHash, err := db.NewRefreshToken()
+	if err != nil {
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, userID, err := db.RotateSession(db.HashToken(req.RefreshToken), newHash, appConfig.Auth.RefreshTTL)
+	if err != nil {
+		if errors.Is(err, db.ErrRefreshTokenReused) {
+			fmt.Printf("Refresh token reuse detected, session revoked\n")
+		} else if !errors.Is(err, db.ErrSessionInvalid) {
+			http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// banned and suspended users can't stay signed in
+	if accountBlocked(w, userID) {
+		if _, err := db.RevokeSession(sessionID, userID); err != nil {
+			fmt.Printf("Failed to revoke session %d: %v\n", sessionID, err)
+		}
+		return
+	}
+
+	writeTokens(w, userID, sessionID, newToken)
+}
+
+// Logout revokes the current session, or every session with {"all": true}
+// Endpoint: POST /api/logout
+func Logout(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	sessionID := auth.SessionID(r.Context())
+
+	var req struct {
+		All bool `json:"all"`
+	}
+	// body is optional
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var err error
+	if req.All {
+		_, err = db.RevokeUserSessions(userID, 0)
+	} else {
+		_, err = db.RevokeSession(sessionID, userID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// ListSessions returns the user's active devices
+// Endpoint: GET /api/sessions
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	sessionID := auth.SessionID(r.Context())
+
+	sessions, err := db.GetUserSessions(userID, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession signs one of the user's devices out
+// Endpoint: DELETE /api/sessions/{id}
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := db.RevokeSession(sessionID, userID)
+	if err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Session revoked"})
+}
+
+// GetSigninHistory lists recent login attempts on the current user's account,
+// including failed ones
+// Endpoint: GET /api/user/signins?limit=20
+func GetSigninHistory(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	limit := 20
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	logs, err := db.GetSigninHistory(userID, limit)
+	if err != nil {
+		http.Error(w, "Failed to load sign-in history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
@@ -5,16 +5,13 @@ import (
 	"net/http"
 	"strconv"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
 )
 
 // StartStudySession starts a new study session
 func StartStudySession(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	var req struct {
 		GroupID *int    `json:"group_id,omitempty"`
@@ -42,11 +39,7 @@ func StartStudySession(w http.ResponseWriter, r *http.Request) {
 
 // EndStudySession ends an active study session
 func EndStudySession(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	sessionIDStr := r.URL.Query().Get("session_id")
 	if sessionIDStr == "" {
@@ -88,11 +81,7 @@ func EndStudySession(w http.ResponseWriter, r *http.Request) {
 
 // GetUserStudySessions returns all study sessions for a user
 func GetUserStudySessions(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Get active sessions
 	activeSessions, err := db.GetActiveSessions(userID)
@@ -117,11 +106,7 @@ func GetUserStudySessions(w http.ResponseWriter, r *http.Request) {
 
 // GetStudyStats returns study statistics for the user
 func GetStudyStats(w http.ResponseWriter, r *http.Request) {
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	stats, err := db.GetUserStudyStats(userID)
 	if err != nil {
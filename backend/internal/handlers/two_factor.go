@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"studybuddy/internal/auth"
+	"studybuddy/internal/db"
+	"studybuddy/internal/totp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const totpIssuer = "StudyBuddy"
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"` // TOTP or recovery code
+}
+
+type TwoFactorRequest struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code
+func verifySecondFactor(userID int, code string) (bool, error) {
+	tf, err := db.GetTwoFactor(userID)
+	if err != nil || !tf.Enabled {
+		return false, err
+	}
+
+	// UseTOTPStep rejects replays again, atomically
+	if step, ok := totp.ValidateAfter(tf.Secret, totp.NormalizeCode(code), time.Now(), tf.LastStep); ok {
+		return db.UseTOTPStep(userID, step)
+	}
+	return db.UseRecoveryCode(userID, code)
+}
+
+// requireSecondFactor checks the code sent with a sensitive request when the
+// user has 2FA on. It writes the error response and returns false on failure.
+func requireSecondFactor(w http.ResponseWriter, userID int, code string) bool {
+	tf, err := db.GetTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return false
+	}
+	if !tf.Enabled {
+		return true
+	}
+	if code == "" {
+		http.Error(w, "Two-factor code required", http.StatusForbidden)
+		return false
+	}
+	ok, err := verifySecondFactor(userID, code)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func checkPassword(userID int, password string) bool {
+	var hashedPassword string
+	err := db.DB.QueryRow(`SELECT password FROM users WHERE id=$1`, userID).Scan(&hashedPassword)
+	return err == nil && bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+// LoginTwoFactor finishes a login that Login answered with a challenge token
+// Endpoint: POST /api/login/2fa
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" || req.Code == "" {
+		http.Error(w, "challenge_token and code required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := auth.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		http.Error(w, "Login expired, sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	var email string
+	if err := db.DB.QueryRow(`SELECT LOWER(email) FROM users WHERE id=$1`, userID).Scan(&email); err != nil {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// wrong codes count as failed logins so the code can't be brute forced
+	ip := clientIP(r)
+	userAgent := r.Header.Get("User-Agent")
+	if !checkLoginThrottle(w, email, ip) {
+		return
+	}
+
+	ok, err := verifySecondFactor(userID, req.Code)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if _, err := db.RecordSignin(userID, email, ip, userAgent, false); err != nil {
+			fmt.Printf("Failed to log failed sign-in: %v\n", err)
+		}
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	if accountBlocked(w, userID) {
+		return
+	}
+	completeLogin(w, r, userID, email)
+}
+
+// GetTwoFactorStatus reports whether 2FA is on and how many recovery codes are left
+// Endpoint: GET /api/2fa
+func GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	tf, err := db.GetTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	remaining := 0
+	if tf.Enabled {
+		remaining, _ = db.CountRecoveryCodes(userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  tf.Enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor creates a new TOTP secret. It only takes effect once a code
+// from it is confirmed with EnableTwoFactor.
+// Endpoint: POST /api/2fa/setup
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !checkPassword(userID, req.Password) {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+
+	tf, err := db.GetTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if tf.Enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := db.SetPendingTOTPSecret(userID, secret); err != nil {
+		http.Error(w, "Failed to start setup", http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	db.DB.QueryRow(`SELECT email FROM users WHERE id=$1`, userID).Scan(&email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_url": totp.ProvisioningURI(secret, totpIssuer, email),
+	})
+}
+
+// EnableTwoFactor confirms setup with a code from the authenticator app and
+// returns the recovery codes. They are only shown this once.
+// Endpoint: POST /api/2fa/enable
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code required", http.StatusBadRequest)
+		return
+	}
+
+	tf, err := db.GetTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if tf.Enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if tf.Secret == "" {
+		http.Error(w, "Start setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(tf.Secret, totp.NormalizeCode(req.Code), time.Now())
+	if !ok {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := db.NewRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := db.EnableTOTP(userID, step, hashes); err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns 2FA off; needs both the password and a code
+// Endpoint: POST /api/2fa/disable
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !checkPassword(userID, req.Password) {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if !requireSecondFactor(w, userID, req.Code) {
+		return
+	}
+
+	if err := db.DisableTOTP(userID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes with a new set
+// Endpoint: POST /api/2fa/recovery-codes
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tf, err := db.GetTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !tf.Enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if !requireSecondFactor(w, userID, req.Code) {
+		return
+	}
+
+	codes, hashes, err := db.NewRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := db.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		http.Error(w, "Failed to save recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
@@ -10,16 +10,15 @@ import (
 	"strconv"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
-	"studybuddy/internal/ws"
+	"studybuddy/internal/models"
 
 	"github.com/gorilla/mux"
 )
 
-// Global hub reference (set in main.go)
-var GlobalHub *ws.Hub
-
 // UploadMessage handles multipart file uploads for a group and creates a message pointing to the uploaded file.
+// Like PostGroupMessage it is idempotent per Idempotency-Key header or clientTempId.
 // Endpoint: POST /api/groups/{id}/messages/upload
 func UploadMessage(w http.ResponseWriter, r *http.Request) {
 	// get group id from URL
@@ -31,15 +30,15 @@ func UploadMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// authenticate user from Authorization header
-	uid, err := GetUserIDFromRequest(r)
-	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	uid := auth.UserID(r.Context())
+	if !IsGroupMember(groupID, uid) {
+		http.Error(w, "you are not a member of this group", http.StatusForbidden)
 		return
 	}
 
-	// parse multipart form (limit to 200MB here; adjust as needed)
-	if err := r.ParseMultipartForm(200 << 20); err != nil {
+	// parse multipart form (limit comes from config)
+	r.Body = http.MaxBytesReader(w, r.Body, appConfig.Uploads.MaxMessageBytes)
+	if err := r.ParseMultipartForm(appConfig.Uploads.MaxMessageBytes); err != nil {
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
 		return
 	}
@@ -54,6 +53,35 @@ func UploadMessage(w http.ResponseWriter, r *http.Request) {
 	// Get clientTempId from form if provided (for deduplication)
 	clientTempId := r.FormValue("clientTempId")
 
+	// a retry of an upload that went through gets the first message back
+	// without storing the file again
+	key, ok := messageKey(r, clientTempId)
+	if !ok {
+		http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+		return
+	}
+	if key != "" {
+		if m, err := db.GetMessageByClientKey(groupID, uid, key); err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			json.NewEncoder(w).Encode(messageResponse(m, clientTempId))
+			return
+		}
+	}
+
+	var replyToID int64
+	if v := r.FormValue("reply_to_id"); v != "" {
+		if replyToID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid reply_to_id", http.StatusBadRequest)
+			return
+		}
+	}
+	parent, reason := replyTarget(groupID, replyToID)
+	if reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+
 	// ensure uploads directory exists
 	uploadDir := filepath.Join("uploads", gidStr)
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -81,60 +109,50 @@ func UploadMessage(w http.ResponseWriter, r *http.Request) {
 	// build accessible URL path (served at /uploads/...)
 	fileURL := fmt.Sprintf("/uploads/%s/%s", gidStr, safeName)
 
-	// get sender name
-	var senderName string
-	_ = db.DB.QueryRow(`SELECT username FROM users WHERE id=$1`, uid).Scan(&senderName)
-
-	// message content will be a JSON object describing the file
-	meta := map[string]interface{}{
-		"type":     "file",
-		"url":      fileURL,
-		"filename": header.Filename,
-		"size":     header.Size,
-		"mime":     header.Header.Get("Content-Type"),
+	// the payload describes the file; content is its name, the plain text
+	// form that is searched and shown by older clients
+	meta := models.FilePayload{
+		URL:      fileURL,
+		Filename: header.Filename,
+		Size:     header.Size,
+		Mime:     header.Header.Get("Content-Type"),
 	}
-	metaBytes, _ := json.Marshal(meta)
-
-	// persist message in DB - ALWAYS use UTC
-	now := time.Now().UTC()
-	var messageID int64
-	err = db.DB.QueryRow(
-		`INSERT INTO messages (group_id, sender_id, sender_name, content, created_at, message_type) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
-		groupID, uid, senderName, string(metaBytes), now, "file",
-	).Scan(&messageID)
+	payload, _ := json.Marshal(meta)
+
+	// persist and broadcast to everyone connected to the group
+	m, created, err := publishMessage(newMessage{
+		GroupID:      groupID,
+		SenderID:     uid,
+		Content:      meta.Filename,
+		Type:         models.MessageTypeFile,
+		Payload:      payload,
+		Parent:       parent,
+		ClientTempID: clientTempId,
+		Key:          key,
+	})
 	if err != nil {
-		// log and continue
-		fmt.Println("failed to save uploaded message:", err)
+		http.Error(w, "failed to save message", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		// a concurrent retry won; keep only its file
+		dst.Close()
+		os.Remove(dstPath)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		json.NewEncoder(w).Encode(messageResponse(m, clientTempId))
+		return
 	}
 
 	// Also create a resource entry so the file appears in Resources tab
-	_, err = db.CreateGroupResource(groupID, uid, header.Filename, fileURL, header.Size, header.Header.Get("Content-Type"))
+	resourceID, err := db.CreateGroupResource(groupID, uid, header.Filename, fileURL, header.Size, header.Header.Get("Content-Type"))
 	if err != nil {
 		fmt.Println("failed to create resource entry:", err)
-	}
-
-	// Broadcast to group's hub so all connected clients get notified
-	payload := map[string]interface{}{
-		"id":           messageID,
-		"group_id":     groupID,
-		"sender_id":    uid,
-		"sender_name":  senderName,
-		"content":      string(metaBytes),
-		"created_at":   now.Format(time.RFC3339),
-		"clientTempId": clientTempId, // Echo back for deduplication
-	}
-	out, _ := json.Marshal(payload)
-
-	// Broadcast via hub if available
-	if GlobalHub != nil {
-		GlobalHub.Broadcast <- ws.Message{
-			GroupID: gidStr,
-			Data:    out,
-			UserID:  uid,
-		}
+	} else {
+		publishResource(resourceID, groupID, uid, header.Filename, fileURL, header.Size, header.Header.Get("Content-Type"))
 	}
 
 	// return file meta
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
+	json.NewEncoder(w).Encode(messageResponse(m, clientTempId))
 }
@@ -1,144 +1,644 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
+	"studybuddy/internal/auth"
 	"studybuddy/internal/db"
+	"studybuddy/internal/models"
 	"studybuddy/internal/ws"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
+// GlobalHub is the realtime gateway's hub (set in main.go). Every chat
+// message goes out through it, however it was sent.
+var GlobalHub *ws.Hub
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	// allow CORS during dev - lock down in prod
-	CheckOrigin: func(r *http.Request) bool { return true },
+	// any origin in dev, only the configured CORS origins otherwise
+	CheckOrigin: ws.CheckOrigin,
 }
 
+// Control frame actions
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsMessage     = "message"
+	wsRead        = "read"
+	wsTyping      = "typing"
+)
+
+// maxWSSubscriptions caps the group channels one connection can follow
+const maxWSSubscriptions = 100
+
+// WSMessage is a frame sent by the client. Action is one of subscribe,
+// unsubscribe, message, read or typing; frames without an action are messages to the
+// group the connection was opened for.
 type WSMessage struct {
-	GroupID    int    `json:"group_id"`
-	SenderID   int    `json:"sender_id"`
-	SenderName string `json:"sender_name"`
-	Content    string `json:"content"`
-	CreatedAt  string `json:"created_at,omitempty"`
+	Action       string          `json:"action,omitempty"`
+	GroupID      int             `json:"group_id,omitempty"`
+	Content      string          `json:"content,omitempty"`
+	MessageType  string          `json:"message_type,omitempty"` // text when left out
+	Payload      json.RawMessage `json:"payload,omitempty"`      // of poll, code and math messages
+	ReplyToID    int64           `json:"reply_to_id,omitempty"`
+	MessageID    int64           `json:"message_id,omitempty"` // read up to this message
+	Typing       bool            `json:"typing,omitempty"`
+	Since        *int64          `json:"since,omitempty"` // catch up on events after this seq
+	ClientTempID string          `json:"clientTempId,omitempty"`
 }
 
-func WsHandler(w http.ResponseWriter, r *http.Request) {
-	// Expect query params: ?group=123&token=<jwt>
-	groupStr := r.URL.Query().Get("group")
-	tokenStr := r.URL.Query().Get("token")
-	if groupStr == "" || tokenStr == "" {
-		http.Error(w, "missing group or token", http.StatusBadRequest)
+// maxMessageKeyLength caps idempotency keys (clientTempId)
+const maxMessageKeyLength = 100
+
+// newMessage is a chat message to be sent
+type newMessage struct {
+	GroupID      int
+	SenderID     int
+	Content      string
+	Type         string
+	Payload      json.RawMessage // see parseMessagePayload
+	Parent       *models.Message // the message it replies to, see replyTarget
+	ClientTempID string          // echoed back to the sender
+	Key          string          // idempotency key, usually the clientTempId
+}
+
+// publishMessage is the one persistence path for chat messages: it saves the
+// message together with its message.created event, pushes it to everyone
+// connected to the group and notifies the members it mentions. The author of
+// the message it replies to gets a reply notification unless they were
+// mentioned. Members who muted the group get neither; announcements notify
+// every member regardless.
+//
+// When the sender already sent a message with the same key, nothing happens
+// and that message is returned with created false.
+func publishMessage(n newMessage) (m *models.Message, created bool, err error) {
+	msg := models.Message{
+		GroupID:     n.GroupID,
+		SenderID:    n.SenderID,
+		Content:     n.Content,
+		MessageType: n.Type,
+		Payload:     n.Payload,
+	}
+	if n.Parent != nil {
+		msg.ReplyToID = &n.Parent.ID
+	}
+	// the message and its event are stored together, or the send fails
+	var event *groupEvent
+	unlock := lockGroupEvents(n.GroupID)
+	m, created, err = db.SaveMessage(msg, n.Key, func(tx *sql.Tx, m *models.Message) error {
+		event = newGroupEvent(n.GroupID, ws.EventMessageCreated, messagePayload(m, n.Parent, n.ClientTempID))
+		return event.store(tx)
+	})
+	if err == nil && created {
+		event.publish()
+	}
+	unlock()
+	if err != nil || !created {
+		return m, created, err
+	}
+
+	if m.MessageType == models.MessageTypeAnnouncement {
+		notifyAnnouncement(m)
+		return m, true, nil
+	}
+
+	mentioned := recordMentions(m)
+	if p := n.Parent; p != nil && p.SenderID != n.SenderID && !slices.Contains(mentioned, p.SenderID) &&
+		IsGroupMember(n.GroupID, p.SenderID) {
+		muted, err := db.IsGroupMuted(n.GroupID, p.SenderID)
+		if err != nil {
+			fmt.Printf("Failed to check whether user %d muted group %d, reply not notified: %v\n", p.SenderID, n.GroupID, err)
+		} else if !muted {
+			db.CreateNotification(p.SenderID, "message_reply", m.SenderName+" replied to your message",
+				m.SenderName+": "+messagePreview(m), &n.GroupID, nil, nil)
+		}
+	}
+	return m, true, nil
+}
+
+// messagePayload is the message.created event of a message; parent is the
+// message it replies to, if loaded
+func messagePayload(m *models.Message, parent *models.Message, clientTempID string) ws.MessagePayload {
+	payload := ws.MessagePayload{
+		ID:           m.ID,
+		GroupID:      m.GroupID,
+		SenderID:     m.SenderID,
+		SenderName:   m.SenderName,
+		Content:      m.Content,
+		MessageType:  m.MessageType,
+		Payload:      m.Payload,
+		CreatedAt:    m.CreatedAt,
+		ReplyToID:    m.ReplyToID,
+		ClientTempID: clientTempID,
+	}
+	if parent != nil && m.ReplyToID != nil && *m.ReplyToID == parent.ID {
+		payload.ReplyTo = &ws.QuotedPayload{
+			ID:          parent.ID,
+			SenderID:    parent.SenderID,
+			SenderName:  parent.SenderName,
+			Content:     quoteContent(parent.Content),
+			MessageType: parent.MessageType,
+		}
+	}
+	return payload
+}
+
+// messageKey returns the idempotency key of an HTTP send: the
+// Idempotency-Key header, or else the clientTempId. ok is false when it is
+// too long.
+func messageKey(r *http.Request, clientTempID string) (key string, ok bool) {
+	key = r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = clientTempID
+	}
+	return key, len(key) <= maxMessageKeyLength
+}
+
+// replyTarget loads the message a new message replies to. It must be a
+// message of the same group that was not deleted; otherwise the returned
+// reason is meant for the user. A zero id means no reply.
+func replyTarget(groupID int, replyToID int64) (*models.Message, string) {
+	if replyToID == 0 {
+		return nil, ""
+	}
+	parent, err := db.GetMessage(replyToID)
+	if err != nil || parent.GroupID != groupID {
+		return nil, "the message you reply to was not found"
+	}
+	if parent.DeletedAt != nil {
+		return nil, "cannot reply to a deleted message"
+	}
+	return parent, ""
+}
+
+// messageResponse is the HTTP response for a message that was just sent
+func messageResponse(m *models.Message, clientTempID string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           m.ID,
+		"group_id":     m.GroupID,
+		"sender_id":    m.SenderID,
+		"sender_name":  m.SenderName,
+		"content":      m.Content,
+		"message_type": m.MessageType,
+		"payload":      m.Payload,
+		"created_at":   m.CreatedAt.Format(time.RFC3339),
+		"reply_to_id":  m.ReplyToID,
+		"clientTempId": clientTempID, // echoed back for deduplication
+	}
+}
+
+// publish sends an event to everyone subscribed to a channel. Replayed
+// events of a group are numbered and stored first, so clients that miss
+// them can catch up.
+func publish(channel string, eventType string, payload interface{}) {
+	if GlobalHub == nil {
 		return
 	}
-	groupID, err := strconv.Atoi(groupStr)
-	if err != nil {
-		http.Error(w, "invalid group id", http.StatusBadRequest)
+	e := ws.NewEnvelope(eventType, payload)
+	if groupID, ok := ws.ParseGroupChannel(channel); ok && ws.ReplayedEvents[eventType] {
+		publishInOrder(&groupEvent{groupID: groupID, channel: channel, e: e})
 		return
 	}
+	if err := GlobalHub.Publish(channel, e); err != nil {
+		fmt.Printf("Failed to publish %s to %s: %v\n", eventType, channel, err)
+	}
+}
+
+// Storage of replayed events (replaced in tests)
+var (
+	withTx             = db.WithTx
+	appendGroupEventTx = db.AppendGroupEventTx
+)
+
+// groupPublishLocks holds a *sync.Mutex per group, see groupEvent
+var groupPublishLocks sync.Map
+
+// groupEvent is a replayed event of a group on its way to subscribers, who
+// must get a group's events in seq order: a client that resumes after seq N
+// must not have missed anything below N. Brokers that publish within the
+// storing transaction (Postgres) send events in commit order, which is seq
+// order on every replica; otherwise the group's lock is held from storing the
+// event until it is handed to the broker.
+type groupEvent struct {
+	groupID int
+	channel string
+	e       ws.Envelope
+	sent    bool // by the broker, on commit
+}
+
+func newGroupEvent(groupID int, eventType string, payload interface{}) *groupEvent {
+	return &groupEvent{groupID: groupID, channel: ws.GroupChannel(groupID), e: ws.NewEnvelope(eventType, payload)}
+}
+
+// lockGroupEvents takes a group's publish lock and returns its unlock
+func lockGroupEvents(groupID int) func() {
+	lock, _ := groupPublishLocks.LoadOrStore(groupID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
 
-	// Validate token and get user id
-	uid, err := GetUserIDFromToken(tokenStr)
+// store numbers the event and stores it in tx; brokers that can publish it
+// when tx commits
+func (ge *groupEvent) store(tx *sql.Tx) error {
+	data, err := json.Marshal(ge.e)
+	if err != nil {
+		return err
+	}
+	seq, err := appendGroupEventTx(tx, ge.groupID, data)
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return err
+	}
+	numbered := ge.e
+	numbered.Seq = seq
+	if GlobalHub != nil {
+		if ge.sent, err = GlobalHub.PublishTx(tx, ge.channel, numbered); err != nil {
+			return err
+		}
+	}
+	ge.e = numbered
+	return nil
+}
+
+// publish hands the stored event to the broker once its transaction
+// committed, unless the broker sent it with the commit
+func (ge *groupEvent) publish() {
+	if ge.sent || GlobalHub == nil {
 		return
 	}
+	if err := GlobalHub.Publish(ge.channel, ge.e); err != nil {
+		fmt.Printf("Failed to publish %s to %s: %v\n", ge.e.Type, ge.channel, err)
+	}
+}
 
-	// Check if user is a member of group (security check)
-	var isMember bool
-	err = db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id=$1 AND user_id=$2)`, groupID, uid).Scan(&isMember)
-	if err != nil || !isMember {
-		// Check if user has a pending join request
-		var hasPending bool
-		_ = db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM join_requests WHERE group_id=$1 AND user_id=$2 AND status='pending')`, groupID, uid).Scan(&hasPending)
-		if hasPending {
-			http.Error(w, "your join request is pending admin approval", http.StatusForbidden)
+// publishInOrder stores and publishes a replayed event of a change that is
+// already saved. An event that can't be stored isn't sent either: without a
+// seq, clients could not tell they missed it.
+func publishInOrder(ge *groupEvent) {
+	defer lockGroupEvents(ge.groupID)()
+	if err := withTx(ge.store); err != nil {
+		fmt.Printf("Failed to store %s for group %d, not sent: %v\n", ge.e.Type, ge.groupID, err)
+		return
+	}
+	ge.publish()
+}
+
+// PushNotification sends a stored notification to the user's open
+// connections (hooked into db.NotificationCreated in main.go)
+func PushNotification(n db.Notification) {
+	publish(ws.UserChannel(n.UserID), ws.EventNotification, ws.NotificationPayload{
+		ID:               n.ID,
+		Type:             n.Type,
+		Title:            n.Title,
+		Message:          n.Message,
+		RelatedGroupID:   n.RelatedGroupID,
+		RelatedSessionID: n.RelatedSessionID,
+		CreatedAt:        n.CreatedAt,
+		ExpiresAt:        n.ExpiresAt,
+	})
+}
+
+// PushRankChange tells a user about their new rank (hooked into
+// db.RankChanged in main.go)
+func PushRankChange(userID int, oldRank string, newRank string) {
+	publish(ws.UserChannel(userID), ws.EventRankChanged, ws.RankPayload{OldRank: oldRank, NewRank: newRank})
+}
+
+// pushJoinDecision tells a user that their join request was approved or
+// rejected, so the client can subscribe to the group right away
+func pushJoinDecision(userID int, groupID int, status string, reason string) {
+	publish(ws.UserChannel(userID), ws.EventJoinRequestDecided, ws.JoinRequestPayload{
+		GroupID: groupID,
+		Status:  status,
+		Reason:  reason,
+	})
+}
+
+// maxReplay is the most events a reconnecting client catches up on; after a
+// longer gap it is told to reload the history instead
+const maxReplay = 100
+
+// subscribeGroup subscribes a connection to a group channel and confirms it
+// with the group's latest seq. With since, the events after it are replayed
+// first and live events are held back until they are through.
+func subscribeGroup(client *ws.Client, groupID int, since *int64) {
+	channel := ws.GroupChannel(groupID)
+	if since == nil {
+		GlobalHub.Subscribe <- ws.Subscription{Client: client, Channel: channel}
+		seq, _ := db.GroupSeq(groupID)
+		GlobalHub.Reply <- ws.Reply{Client: client, Event: ws.NewEnvelope(ws.EventSubscribed, ws.SubscriptionPayload{GroupID: groupID, Seq: seq})}
+		return
+	}
+
+	// subscribed before loading, so nothing published meanwhile is lost
+	GlobalHub.Subscribe <- ws.Subscription{Client: client, Channel: channel, CatchUp: true}
+	events, seq := replayGroup(groupID, *since)
+	events = append(events, ws.NewEnvelope(ws.EventSubscribed, ws.SubscriptionPayload{GroupID: groupID, Seq: seq}))
+	GlobalHub.CaughtUp <- ws.CatchUp{Client: client, Channel: channel, Events: events, Seq: seq}
+}
+
+// replayGroup loads the events of a group after since. When they can't all be
+// replayed (too many, pruned, or since is unknown) it returns a resync event
+// instead. seq is the last event covered.
+func replayGroup(groupID int, since int64) ([]ws.Envelope, int64) {
+	latest, err := db.GroupSeq(groupID)
+	if err != nil {
+		fmt.Printf("Failed to load seq of group %d: %v\n", groupID, err)
+		return []ws.Envelope{ws.NewEnvelope(ws.EventResync, ws.ResyncPayload{GroupID: groupID})}, 0
+	}
+	resync := []ws.Envelope{ws.NewEnvelope(ws.EventResync, ws.ResyncPayload{GroupID: groupID, Seq: latest})}
+	if since > latest {
+		return resync, latest
+	}
+
+	stored, err := db.GetGroupEventsSince(groupID, since, maxReplay+1)
+	if err != nil {
+		fmt.Printf("Failed to load events of group %d: %v\n", groupID, err)
+		return resync, latest
+	}
+	if len(stored) > maxReplay || (since < latest && (len(stored) == 0 || stored[0].Seq != since+1)) {
+		return resync, latest
+	}
+
+	events := make([]ws.Envelope, 0, len(stored)+1)
+	seq := since
+	for _, s := range stored {
+		e, err := ws.DecodeEnvelope(s.Event)
+		if err != nil {
+			fmt.Printf("Failed to decode event %d of group %d: %v\n", s.Seq, groupID, err)
+			return resync, latest
+		}
+		e.Seq = s.Seq
+		events = append(events, e)
+		seq = s.Seq
+	}
+	return events, seq
+}
+
+// groupReadAccess reports whether a user may follow a group's chat: members
+// can, others only when the group allows viewing without joining. The
+// returned reason is meant for the user.
+func groupReadAccess(groupID int, userID int) (bool, string) {
+	if IsGroupMember(groupID, userID) {
+		return true, ""
+	}
+	// Check if user has a pending join request
+	var hasPending bool
+	_ = db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM join_requests WHERE group_id=$1 AND user_id=$2 AND status='pending')`, groupID, userID).Scan(&hasPending)
+	if hasPending {
+		return false, "your join request is pending admin approval"
+	}
+	// Check if group is public and allows without join (optional)
+	var allowWithoutJoin bool
+	_ = db.DB.QueryRow(`SELECT allow_content_view_without_join FROM groups WHERE id=$1`, groupID).Scan(&allowWithoutJoin)
+	if !allowWithoutJoin {
+		return false, "you are not a member of this group"
+	}
+	// Don't auto-add users, they must join properly first
+	return true, ""
+}
+
+// WsHandler is the realtime gateway. Clients negotiate the protocol version
+// with the studybuddy.v<N> subprotocol; events are sent as ws.Envelope. One
+// connection per user carries the
+// user's personal channel (notifications, join request decisions, rank
+// changes) and any number of group channels, which are joined and left with
+// control frames:
+//
+//	{"action": "subscribe", "group_id": 5}
+//	{"action": "subscribe", "group_id": 5, "since": 120}
+//	{"action": "unsubscribe", "group_id": 5}
+//	{"action": "message", "group_id": 5, "content": "hi", "clientTempId": "c_1"}
+//	{"action": "message", "group_id": 5, "content": "yes", "reply_to_id": 42}
+//	{"action": "message", "group_id": 5, "message_type": "code", "payload": {"language": "go", "code": "..."}}
+//	{"action": "read", "group_id": 5, "message_id": 43}
+//	{"action": "typing", "group_id": 5, "typing": true}
+//
+// Access is checked on every subscribe. Opening the socket for a group
+// subscribes to it right away. The token is checked by the auth middleware
+// (?token= for browsers).
+// Endpoint: GET /ws, GET /ws/{groupID} (also GET /ws?group=)
+func WsHandler(w http.ResponseWriter, r *http.Request) {
+	groupStr := mux.Vars(r)["groupID"]
+	if groupStr == "" {
+		groupStr = r.URL.Query().Get("group")
+	}
+	initialGroup := 0
+	if groupStr != "" {
+		id, err := strconv.Atoi(groupStr)
+		if err != nil {
+			http.Error(w, "invalid group id", http.StatusBadRequest)
 			return
 		}
-		// Check if group is public and allows without join (optional)
-		var allowWithoutJoin bool
-		_ = db.DB.QueryRow(`SELECT allow_content_view_without_join FROM groups WHERE id=$1`, groupID).Scan(&allowWithoutJoin)
-		if !allowWithoutJoin {
-			http.Error(w, "you are not a member of this group", http.StatusForbidden)
+		initialGroup = id
+	}
+	// resume the initial group after the last event seen
+	var initialSince *int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || since < 0 {
+			http.Error(w, "invalid since", http.StatusBadRequest)
 			return
 		}
-		// Don't auto-add users, they must join properly first
+		initialSince = &since
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	uid := auth.UserID(r.Context())
+
+	// fail the handshake rather than the first frame for the initial group
+	if initialGroup != 0 {
+		if ok, reason := groupReadAccess(initialGroup, uid); !ok {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+	}
+
+	version, ok := ws.NegotiateVersion(r)
+	if !ok {
+		http.Error(w, "unsupported protocol version, this server speaks "+ws.Subprotocol(ws.ProtocolVersion), http.StatusBadRequest)
+		return
+	}
+	var header http.Header
+	if version != ws.LegacyVersion {
+		header = http.Header{"Sec-WebSocket-Protocol": {ws.Subprotocol(version)}}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		return
 	}
 
-	hub := ws.GetHub(groupID)
 	client := &ws.Client{
-		Hub:     hub,
+		Hub:     GlobalHub,
 		Conn:    conn,
 		Send:    make(chan []byte, 256),
-		GroupID: strconv.Itoa(groupID),
 		UserID:  uid,
+		Version: version,
+
+		MaxFrameSize: maxFrameSize,
+	}
+	GlobalHub.Register <- client
+
+	// shown in typing events
+	var username string
+	db.DB.QueryRow(`SELECT COALESCE(NULLIF(username, ''), email, '') FROM users WHERE id=$1`, uid).Scan(&username)
+
+	// groups this connection follows and when it last sent "typing" to
+	// each; only touched by the read pump
+	subscribed := make(map[int]bool)
+	typingSent := make(map[int]time.Time)
+	if initialGroup != 0 {
+		subscribeGroup(client, initialGroup, initialSince)
+		subscribed[initialGroup] = true
+	}
+
+	reply := func(eventType string, payload interface{}) {
+		GlobalHub.Reply <- ws.Reply{Client: client, Event: ws.NewEnvelope(eventType, payload)}
+	}
+	replyError := func(groupID int, msg string) {
+		reply(ws.EventError, ws.ErrorPayload{GroupID: groupID, Error: msg})
 	}
-	hub.Register <- client
 
-	// handle messages - when client writes, persist and broadcast
 	onMessage := func(msgBytes []byte) {
 		var m WSMessage
 		if err := json.Unmarshal(msgBytes, &m); err != nil {
+			replyError(0, "invalid frame")
 			return
 		}
-		// populate fields (trust sender id from token instead of client)
-		m.GroupID = groupID
-		m.SenderID = uid
-
-		// fetch sender name
-		var senderName string
-		dbErr := db.DB.QueryRow(`SELECT username FROM users WHERE id=$1`, uid).Scan(&senderName)
-		if dbErr != nil || senderName == "" {
-			// fallback to email if username not found
-			_ = db.DB.QueryRow(`SELECT email FROM users WHERE id=$1`, uid).Scan(&senderName)
-		}
-		if senderName == "" {
-			senderName = "User" // final fallback
+		if m.Action == "" {
+			m.Action = wsMessage
 		}
-		m.SenderName = senderName
-
-		// persist message in DB - ALWAYS use UTC
-		now := time.Now().UTC()
-		_, err := db.DB.Exec(
-			`INSERT INTO messages (group_id, sender_id, sender_name, content, created_at, message_type) VALUES ($1,$2,$3,$4,$5,$6)`,
-			m.GroupID, m.SenderID, m.SenderName, m.Content, now, "text",
-		)
-		if err != nil {
-			// log but continue to broadcast
-			fmt.Println("failed to save message:", err)
+		if m.GroupID == 0 {
+			m.GroupID = initialGroup
 		}
-		m.CreatedAt = now.Format(time.RFC3339)
-
-		// Explicitly build response to ensure all fields are included
-		response := map[string]interface{}{
-			"group_id":    m.GroupID,
-			"sender_id":   m.SenderID,
-			"sender_name": m.SenderName,
-			"content":     m.Content,
-			"created_at":  m.CreatedAt,
+		if m.GroupID <= 0 {
+			replyError(0, "group_id required")
+			return
 		}
 
-		// marshal and broadcast to hub
-		out, _ := json.Marshal(response)
-		fmt.Printf("Broadcasting message: %s\n", string(out)) // DEBUG
-		hub.Broadcast <- ws.Message{
-			GroupID: strconv.Itoa(groupID),
-			Data:    out,
-			UserID:  uid,
+		switch m.Action {
+		case wsSubscribe:
+			// subscribing again with since catches up once more
+			if subscribed[m.GroupID] && m.Since == nil {
+				reply(ws.EventSubscribed, ws.SubscriptionPayload{GroupID: m.GroupID})
+				return
+			}
+			if !subscribed[m.GroupID] && len(subscribed) >= maxWSSubscriptions {
+				replyError(m.GroupID, "too many subscriptions")
+				return
+			}
+			if ok, reason := groupReadAccess(m.GroupID, uid); !ok {
+				replyError(m.GroupID, reason)
+				return
+			}
+			subscribeGroup(client, m.GroupID, m.Since)
+			subscribed[m.GroupID] = true
+
+		case wsUnsubscribe:
+			GlobalHub.Unsubscribe <- ws.Subscription{Client: client, Channel: ws.GroupChannel(m.GroupID)}
+			delete(subscribed, m.GroupID)
+			reply(ws.EventUnsubscribed, ws.SubscriptionPayload{GroupID: m.GroupID})
+
+		case wsMessage:
+			if m.Content == "" && m.MessageType == "" {
+				return
+			}
+			// membership is checked per message so removed members stop posting
+			if !IsGroupMember(m.GroupID, uid) {
+				replyError(m.GroupID, "you are not a member of this group")
+				return
+			}
+			payload, content, reason := parseMessagePayload(m.MessageType, m.Content, m.Payload)
+			if reason == "" {
+				reason = messageTypeAllowed(m.GroupID, uid, m.MessageType)
+			}
+			if reason != "" {
+				replyError(m.GroupID, reason)
+				return
+			}
+			if m.MessageType == "" {
+				m.MessageType = models.MessageTypeText
+			}
+			parent, reason := replyTarget(m.GroupID, m.ReplyToID)
+			if reason != "" {
+				replyError(m.GroupID, reason)
+				return
+			}
+			if len(m.ClientTempID) > maxMessageKeyLength {
+				replyError(m.GroupID, "clientTempId is too long")
+				return
+			}
+			saved, created, err := publishMessage(newMessage{
+				GroupID:      m.GroupID,
+				SenderID:     uid,
+				Content:      content,
+				Type:         m.MessageType,
+				Payload:      payload,
+				Parent:       parent,
+				ClientTempID: m.ClientTempID,
+				Key:          m.ClientTempID,
+			})
+			if err != nil {
+				fmt.Println("failed to save message:", err)
+				replyError(m.GroupID, "failed to save message")
+			} else if !created {
+				// a retry: only the sender needs to hear about it again
+				reply(ws.EventMessageCreated, messagePayload(saved, parent, m.ClientTempID))
+			}
+
+		case wsTyping:
+			// typing events aren't stored, they only go to who is connected
+			if m.Typing {
+				if time.Since(typingSent[m.GroupID]) < typingInterval {
+					return
+				}
+				typingSent[m.GroupID] = time.Now()
+			} else {
+				delete(typingSent, m.GroupID)
+			}
+			if !IsGroupMember(m.GroupID, uid) {
+				replyError(m.GroupID, "you are not a member of this group")
+				return
+			}
+			publish(ws.GroupChannel(m.GroupID), ws.EventTyping, ws.TypingPayload{
+				GroupID:  m.GroupID,
+				UserID:   uid,
+				Username: username,
+				Typing:   m.Typing,
+			})
+
+		case wsRead:
+			if !IsGroupMember(m.GroupID, uid) {
+				replyError(m.GroupID, "you are not a member of this group")
+				return
+			}
+			// the new state reaches this connection through the user channel
+			_, reason, err := markRead(m.GroupID, uid, m.MessageID)
+			if err != nil {
+				fmt.Println("failed to update read state:", err)
+				replyError(m.GroupID, "failed to update read state")
+			} else if reason != "" {
+				replyError(m.GroupID, reason)
+			}
+
+		default:
+			replyError(m.GroupID, "unknown action")
 		}
 	}
 
 	// start pumps
 	go client.WritePump()
-	go client.ReadPump(onMessage)
+	go client.ReadPump(onMessage, func() {
+		replyError(0, "frame too large")
+	})
 }
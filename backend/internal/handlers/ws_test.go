@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"studybuddy/internal/ws"
+)
+
+// fakeEventStore replaces the storage of replayed events with a counter
+// standing in for the group's event_seq; fail makes appends fail
+func fakeEventStore(t *testing.T, fail bool) {
+	var mu sync.Mutex
+	var seq int64
+	storedTx, storedAppend := withTx, appendGroupEventTx
+	t.Cleanup(func() { withTx, appendGroupEventTx = storedTx, storedAppend })
+
+	withTx = func(fn func(tx *sql.Tx) error) error { return fn(nil) }
+	appendGroupEventTx = func(tx *sql.Tx, groupID int, event []byte) (int64, error) {
+		if fail {
+			return 0, errors.New("database is down")
+		}
+		mu.Lock()
+		seq++
+		n := seq
+		mu.Unlock()
+		// lets concurrent publishes race between numbering and publishing
+		time.Sleep(time.Millisecond)
+		return n, nil
+	}
+}
+
+// subscribedClient connects a client to a group's channel on a fresh hub
+func subscribedClient(t *testing.T, groupID int, buffer int) *ws.Client {
+	hub := ws.NewHub()
+	go hub.Run()
+	GlobalHub = hub
+	t.Cleanup(func() { GlobalHub = nil })
+
+	c := &ws.Client{Hub: hub, Send: make(chan []byte, buffer), UserID: 1, Version: ws.ProtocolVersion}
+	hub.Register <- c
+	hub.Subscribe <- ws.Subscription{Client: c, Channel: ws.GroupChannel(groupID)}
+	return c
+}
+
+func TestPublishInOrder(t *testing.T) {
+	fakeEventStore(t, false)
+	const events = 50
+	c := subscribedClient(t, 1, events+1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < events; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publish(ws.GroupChannel(1), ws.EventMessageDeleted, ws.MessageDeletedPayload{GroupID: 1})
+		}()
+	}
+	wg.Wait()
+
+	for want := int64(1); want <= events; want++ {
+		select {
+		case data := <-c.Send:
+			e, err := ws.DecodeEnvelope(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Seq != want {
+				t.Fatalf("got seq %d, want %d", e.Seq, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for seq %d", want)
+		}
+	}
+}
+
+func TestPublishDropsUnstoredEvents(t *testing.T) {
+	fakeEventStore(t, true)
+	c := subscribedClient(t, 1, 2)
+
+	// a replayed event that can't be stored would leave a silent gap
+	publish(ws.GroupChannel(1), ws.EventMessageDeleted, ws.MessageDeletedPayload{GroupID: 1})
+	// live-only events don't need storing
+	publish(ws.GroupChannel(1), ws.EventTyping, ws.TypingPayload{GroupID: 1})
+
+	select {
+	case data := <-c.Send:
+		e, err := ws.DecodeEnvelope(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != ws.EventTyping {
+			t.Fatalf("got %s, want only the typing event", e.Type)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the typing event")
+	}
+}
@@ -0,0 +1,136 @@
+// Package mail sends transactional emails such as verification links and
+// password resets.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"studybuddy/internal/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the mailer selected by cfg.Driver
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case config.MailLog:
+		return LogMailer{From: cfg.From}, nil
+	case config.MailFile:
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, err
+		}
+		return &FileMailer{From: cfg.From, Dir: cfg.Dir}, nil
+	case config.MailSMTP:
+		return &SMTPMailer{
+			From:     cfg.From,
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUser,
+			Password: cfg.SMTPPassword,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// LogMailer prints messages to stdout instead of sending them
+type LogMailer struct {
+	From string
+}
+
+func (m LogMailer) Send(msg Message) error {
+	fmt.Printf("📧 Mail to %s: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes every message as an .eml file into Dir, which makes the
+// links easy to pick up in local testing
+type FileMailer struct {
+	From string
+	Dir  string
+
+	seq atomic.Int64
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	data, err := render(m.From, msg)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405"), m.seq.Add(1))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0644)
+}
+
+// SMTPMailer sends through an SMTP relay. The connection is upgraded with
+// STARTTLS when the server offers it.
+type SMTPMailer struct {
+	From     string
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	data, err := render(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var a smtp.Auth
+	if m.Username != "" {
+		a = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, a, from.Address, []string{msg.To}, data)
+}
+
+// ValidAddress reports whether addr is a single bare email address
+func ValidAddress(addr string) bool {
+	a, err := mail.ParseAddress(addr)
+	return err == nil && a.Address == addr
+}
+
+// render builds the RFC 5322 message
+func render(from string, msg Message) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	// header injection guard; the subject is the only free-form header
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("invalid subject")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes(), nil
+}
@@ -1,14 +1,89 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Message types
+const (
+	MessageTypeText = "text"
+	MessageTypeFile = "file"
+	MessageTypePoll = "poll"
+	MessageTypeCode = "code"
+	MessageTypeMath = "math"
+	// posted by group admins, notifies every member
+	MessageTypeAnnouncement = "announcement"
+)
+
 type Message struct {
-	ID        int64     `json:"id" db:"id"`
-	GroupID   string    `json:"group_id" db:"group_id"`
-	SenderID  int       `json:"sender_id" db:"sender_id"`
-	SenderName string   `json:"sender_name" db:"sender_name"`
-	Content   string    `json:"content" db:"content"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          int64      `json:"id" db:"id"`
+	GroupID     int        `json:"group_id" db:"group_id"`
+	SenderID    int        `json:"sender_id" db:"sender_id"`
+	SenderName  string     `json:"sender_name" db:"sender_name"`
+	Content     string     `json:"content" db:"content"`
+	MessageType string     `json:"message_type" db:"message_type"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	EditedAt    *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	ReplyToID   *int64     `json:"reply_to_id,omitempty" db:"reply_to_id"`
+	// the typed data of file, poll, code and math messages; Content is
+	// their plain text rendering
+	Payload json.RawMessage `json:"payload,omitempty" db:"payload"`
+
+	// only filled in when listing messages
+	ReplyCount int            `json:"reply_count,omitempty" db:"-"`
+	ReplyTo    *QuotedMessage `json:"reply_to,omitempty" db:"-"`
+	Pinned     bool           `json:"pinned,omitempty" db:"-"`
+}
+
+// QuotedMessage is the message a reply answers
+type QuotedMessage struct {
+	ID          int64  `json:"id"`
+	SenderID    int    `json:"sender_id"`
+	SenderName  string `json:"sender_name"`
+	Content     string `json:"content"`
+	MessageType string `json:"message_type"`
+	Deleted     bool   `json:"deleted,omitempty"`
+}
+
+// MessageRevision is an earlier version of an edited message
+type MessageRevision struct {
+	Content  string    `json:"content"`
+	EditedBy *int      `json:"edited_by,omitempty"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// FilePayload is an uploaded file
+type FilePayload struct {
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Mime     string `json:"mime"`
+}
+
+// PollPayload is a poll; votes are stored apart and tallied in PollResults
+type PollPayload struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multiple_choice,omitempty"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+}
+
+// PollResults are the votes on a poll so far
+type PollResults struct {
+	Counts  []int `json:"counts"` // per option
+	Voters  int   `json:"voters"`
+	MyVotes []int `json:"my_votes"` // the viewer's options
+}
+
+// CodePayload is a code snippet
+type CodePayload struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+}
+
+// MathPayload is a LaTeX math block
+type MathPayload struct {
+	LaTeX string `json:"latex"`
 }
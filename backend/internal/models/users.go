@@ -26,4 +26,6 @@ type User struct {
 	ShowUniversity       bool       `json:"show_university" db:"show_university"`
 	ShowBio              bool       `json:"show_bio" db:"show_bio"`
 	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
+	EmailVerified        bool       `json:"email_verified,omitempty" db:"-"` // only filled for the owner's profile
+	NotifyNewSignin      bool       `json:"notify_new_signin,omitempty" db:"notify_new_signin"`
 }
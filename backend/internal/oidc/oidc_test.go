@@ -0,0 +1,214 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"studybuddy/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP is an OpenID provider with an RSA and an EC signing key. Its token
+// endpoint checks the PKCE verifier against the challenge of the last
+// authorization request and returns the token built by idToken.
+type mockIdP struct {
+	*httptest.Server
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	challenge string
+	idToken   func() string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{rsaKey: rsaKey, ecKey: ecKey}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{
+			{Kid: "rsa", Kty: "RSA", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kid: "ec", Kty: "EC", Use: "sig", Crv: "P-256", X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			// encryption keys are never used to verify
+			{Kid: "enc", Kty: "RSA", Use: "enc", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "good-code" || b64(sum[:]) != idp.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken()})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	var method jwt.SigningMethod
+	var key crypto.Signer
+	switch kid {
+	case "ec":
+		method, key = jwt.SigningMethodES256, idp.ecKey
+	default:
+		method, key = jwt.SigningMethodRS256, idp.rsaKey
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (idp *mockIdP) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            "studybuddy",
+		"sub":            "user-1",
+		"email":          "Ada@Example.edu",
+		"email_verified": "true",
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+// login runs the flow up to the code exchange and returns its result
+func (idp *mockIdP) login(t *testing.T, p *Provider, verifierOverride string) (*Identity, error) {
+	t.Helper()
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("nonce") != "nonce-1" || q.Get("client_id") != "studybuddy" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	idp.challenge = q.Get("code_challenge")
+
+	if verifierOverride != "" {
+		verifier = verifierOverride
+	}
+	return p.Exchange(context.Background(), "good-code", verifier, "nonce-1")
+}
+
+func newProvider(issuer string) *Provider {
+	return New(config.OIDCConfig{
+		Name:        "Campus",
+		Issuer:      issuer,
+		ClientID:    "studybuddy",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{"openid", "email"},
+	})
+}
+
+func TestExchange(t *testing.T) {
+	for _, kid := range []string{"rsa", "ec"} {
+		t.Run(kid, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.idToken = func() string { return idp.sign(t, kid, idp.claims("nonce-1")) }
+
+			id, err := idp.login(t, newProvider(idp.URL), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Issuer != idp.URL || id.Subject != "user-1" || id.Email != "ada@example.edu" || !id.EmailVerified {
+				t.Errorf("unexpected identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestExchangeSendsPKCEVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.idToken = func() string { return idp.sign(t, "rsa", idp.claims("nonce-1")) }
+
+	if _, err := idp.login(t, newProvider(idp.URL), "not-the-verifier"); err == nil {
+		t.Fatal("exchange succeeded with the wrong code verifier")
+	}
+}
+
+func TestExchangeRejectsBadTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		kid    string
+		change func(c jwt.MapClaims)
+	}{
+		{"wrong issuer", "rsa", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"wrong audience", "ec", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"bad nonce", "rsa", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"no nonce", "rsa", func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{"expired", "ec", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", "rsa", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", "rsa", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"unknown key", "other", func(c jwt.MapClaims) {}},
+		{"encryption key", "enc", func(c jwt.MapClaims) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.idToken = func() string {
+				claims := idp.claims("nonce-1")
+				tt.change(claims)
+				return idp.sign(t, tt.kid, claims)
+			}
+			if id, err := idp.login(t, newProvider(idp.URL), ""); err == nil {
+				t.Fatalf("accepted token, identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+	idp := newMockIdP(t)
+
+	// the provider must publish the issuer it is configured with
+	p := newProvider(idp.URL + "/")
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("got %v, want an issuer mismatch", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package totp implements RFC 6238 time-based one-time passwords as used by
+// authenticator apps (HMAC-SHA1, 6 digits, 30 second steps).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 // seconds per step
+
+	// skew is how many steps before and after now are accepted, to allow for
+	// clock drift and slow typing
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160 bit secret in base32
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// Step returns the time step t falls into
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// CodeAt returns the code for a time step
+func CodeAt(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// NormalizeCode strips the spaces users type or paste along with a code,
+// as in "123 456"
+func NormalizeCode(code string) string {
+	return strings.Join(strings.Fields(code), "")
+}
+
+// Validate checks code against the steps around t and returns the matching
+// step. Callers must reject steps that were already used to stop replays,
+// see ValidateAfter.
+func Validate(secret string, code string, t time.Time) (int64, bool) {
+	return ValidateAfter(secret, code, t, 0)
+}
+
+// ValidateAfter is Validate for a secret whose codes were accepted up to
+// lastStep: codes of that step or earlier ones are replays and rejected.
+func ValidateAfter(secret string, code string, t time.Time, lastStep int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for i := -skew; i <= skew; i++ {
+		if now+int64(i) <= lastStep {
+			continue
+		}
+		expected, err := CodeAt(secret, now+int64(i))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return now + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI builds the otpauth:// URI that authenticator apps scan as a
+// QR code
+func ProvisioningURI(secret string, issuer string, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA1 seed of RFC 6238 appendix B, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAtRFC6238(t *testing.T) {
+	// appendix B lists 8 digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, _ := CodeAt(rfcSecret, step+offset)
+		got, ok := Validate(rfcSecret, code, now)
+		want := offset >= -skew && offset <= skew
+		if ok != want {
+			t.Errorf("code of step %+d accepted = %v, want %v", offset, ok, want)
+		}
+		if ok && got != step+offset {
+			t.Errorf("code of step %+d matched step %d", offset, got-step)
+		}
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Error("accepted a short code")
+	}
+}
+
+func TestValidateAfterRejectsReplays(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	code, _ := CodeAt(rfcSecret, step)
+
+	got, ok := ValidateAfter(rfcSecret, code, now, step-1)
+	if !ok || got != step {
+		t.Fatalf("fresh code rejected")
+	}
+	// the same code again, or one of an earlier step still in the window
+	if _, ok := ValidateAfter(rfcSecret, code, now, step); ok {
+		t.Error("accepted a replayed code")
+	}
+	earlier, _ := CodeAt(rfcSecret, step-1)
+	if _, ok := ValidateAfter(rfcSecret, earlier, now, step); ok {
+		t.Error("accepted a code older than the last accepted one")
+	}
+	// a later code in the window is still fine
+	later, _ := CodeAt(rfcSecret, step+1)
+	if got, ok := ValidateAfter(rfcSecret, later, now, step); !ok || got != step+1 {
+		t.Error("rejected the next step's code")
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	for in, want := range map[string]string{
+		"123456":      "123456",
+		" 123 456\n":  "123456",
+		"12\t34  56 ": "123456",
+		"":            "",
+	} {
+		if got := NormalizeCode(in); got != want {
+			t.Errorf("NormalizeCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Broker carries events between the hubs of all server replicas. Every hub
+// publishes to the broker and broadcasts what the broker delivers, so an
+// event published on one replica reaches sockets on all of them.
+type Broker interface {
+	// Publish sends an event to the channel on every replica, this one
+	// included
+	Publish(channel string, e Envelope) error
+	// Subscribe registers the function that receives every published event
+	Subscribe(deliver func(channel string, e Envelope)) error
+	Close() error
+}
+
+// TxBroker is a broker that can publish as part of a database transaction:
+// the event is only sent if the transaction commits, and events of
+// transactions are sent in commit order
+type TxBroker interface {
+	PublishTx(tx *sql.Tx, channel string, e Envelope) error
+}
+
+// MemoryBroker delivers events within the process. It is enough for a
+// single replica.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(channel string, e Envelope)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(channel string, e Envelope) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, deliver := range b.handlers {
+		deliver(channel, e)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(deliver func(channel string, e Envelope)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, deliver)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = nil
+	return nil
+}
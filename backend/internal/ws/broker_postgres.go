@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// notifyChannel is the Postgres channel all replicas listen on
+const notifyChannel = "studybuddy_realtime"
+
+// maxNotifyPayload keeps NOTIFY payloads below Postgres' 8000 byte limit;
+// larger events go through the realtime_events table
+const maxNotifyPayload = 7900
+
+// brokerFrame is the NOTIFY payload: an event, or the id of a stored one
+type brokerFrame struct {
+	Channel string          `json:"channel,omitempty"`
+	Event   json.RawMessage `json:"event,omitempty"`
+	Ref     int64           `json:"ref,omitempty"`
+}
+
+// PostgresBroker fans events out to all replicas with LISTEN/NOTIFY
+type PostgresBroker struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	mu      sync.Mutex
+	deliver func(channel string, e Envelope)
+	done    chan struct{}
+}
+
+// NewPostgresBroker listens on its own connection opened from connString and
+// publishes through db
+func NewPostgresBroker(db *sql.DB, connString string) (*PostgresBroker, error) {
+	listener := pq.NewListener(connString, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Printf("realtime broker: lost connection: %v", err)
+		case pq.ListenerEventReconnected:
+			// events published while disconnected are lost
+			log.Printf("realtime broker: reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("realtime broker: reconnect failed: %v", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &PostgresBroker{db: db, listener: listener, done: make(chan struct{})}, nil
+}
+
+func (b *PostgresBroker) Publish(channel string, e Envelope) error {
+	return b.publish(b.db, channel, e)
+}
+
+// PublishTx sends the event when tx commits. Postgres delivers the
+// notifications of transactions in commit order.
+func (b *PostgresBroker) PublishTx(tx *sql.Tx, channel string, e Envelope) error {
+	return b.publish(tx, channel, e)
+}
+
+// execer is a connection pool or a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (b *PostgresBroker) publish(db execer, channel string, e Envelope) error {
+	event, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(brokerFrame{Channel: channel, Event: event})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		var id int64
+		err := db.QueryRow(`INSERT INTO realtime_events (channel, event) VALUES ($1, $2) RETURNING id`,
+			channel, string(event)).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(`DELETE FROM realtime_events WHERE created_at < NOW() - INTERVAL '5 minutes'`); err != nil {
+			log.Printf("realtime broker: pruning stored events: %v", err)
+		}
+		payload, _ = json.Marshal(brokerFrame{Ref: id})
+	}
+
+	_, err = db.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, string(payload))
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(deliver func(channel string, e Envelope)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.deliver != nil {
+		return errors.New("realtime broker: already subscribed")
+	}
+	b.deliver = deliver
+	go b.listen()
+	return nil
+}
+
+func (b *PostgresBroker) listen() {
+	for {
+		select {
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			// nil after a reconnect
+			if n == nil {
+				continue
+			}
+			channel, e, err := b.decode(n.Extra)
+			if err != nil {
+				log.Printf("realtime broker: dropping event: %v", err)
+				continue
+			}
+			b.deliver(channel, e)
+		case <-time.After(90 * time.Second):
+			// notices dead connections that didn't error yet
+			go b.listener.Ping()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *PostgresBroker) decode(payload string) (string, Envelope, error) {
+	var f brokerFrame
+	if err := json.Unmarshal([]byte(payload), &f); err != nil {
+		return "", Envelope{}, err
+	}
+	if f.Ref != 0 {
+		var event string
+		err := b.db.QueryRow(`SELECT channel, event FROM realtime_events WHERE id = $1`, f.Ref).Scan(&f.Channel, &event)
+		if err != nil {
+			return "", Envelope{}, err
+		}
+		f.Event = json.RawMessage(event)
+	}
+
+	e, err := DecodeEnvelope(f.Event)
+	if err != nil {
+		return "", Envelope{}, err
+	}
+	return f.Channel, e, nil
+}
+
+func (b *PostgresBroker) Close() error {
+	close(b.done)
+	return b.listener.Close()
+}
@@ -1,6 +1,7 @@
 package ws
 
 import (
+	"io"
 	"log"
 	"time"
 
@@ -12,6 +13,9 @@ const (
 	pongWait   = 60 * time.Second
 	pingPeriod = (pongWait * 9) / 10
 	writeWait  = 10 * time.Second
+
+	// frame size limit when the client sets none
+	defaultMaxFrameSize = 512
 )
 
 // Client represents a single connection
@@ -19,18 +23,31 @@ type Client struct {
 	Hub     *Hub
 	Conn    *websocket.Conn
 	Send    chan []byte
-	GroupID string
 	UserID  int
+	Version int // negotiated protocol version
+
+	// frames larger than this are skipped, see ReadPump
+	MaxFrameSize int64
+
+	// owned by the hub goroutine
+	channels   map[string]bool
+	catchingUp map[string][]Envelope // live events held back per channel
 }
 
-// Read messages from WebSocket and forward to the hub
-func (c *Client) ReadPump(onMessage func([]byte)) {
+// Read messages from WebSocket and hand them to onMessage, which persists
+// and broadcasts them. Frames over MaxFrameSize are read past without being
+// kept, and onTooLarge is called instead so the client can be told; the
+// connection stays open.
+func (c *Client) ReadPump(onMessage func([]byte), onTooLarge func()) {
 	defer func() {
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(512)
+	maxSize := c.MaxFrameSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFrameSize
+	}
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -38,23 +55,39 @@ func (c *Client) ReadPump(onMessage func([]byte)) {
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		message, tooLarge, err := readFrame(c.Conn, maxSize)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("ws read error: %v", err)
 			}
 			break
 		}
-		if onMessage != nil {
-			onMessage(message)
-		} else {
-			c.Hub.Broadcast <- Message{
-				GroupID: c.GroupID,
-				Data:    message,
-				UserID:  c.UserID,
-			}
+		if tooLarge {
+			onTooLarge()
+			continue
+		}
+		onMessage(message)
+	}
+}
+
+// readFrame reads the next data frame, keeping at most maxSize bytes. The
+// rest of a larger frame is discarded and tooLarge is set.
+func readFrame(conn *websocket.Conn, maxSize int64) (message []byte, tooLarge bool, err error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, false, err
+	}
+	message, err = io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(message)) > maxSize {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, false, err
 		}
+		return nil, true, nil
 	}
+	return message, false, nil
 }
 
 // Write messages from hub to WebSocket
@@ -74,18 +107,8 @@ func (c *Client) WritePump() {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-			// send queued messages too
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
-			if err := w.Close(); err != nil {
+			// one event per frame so clients can parse each as JSON
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReadPumpSkipsLargeFrames(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	got := make(chan string, 10)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c := &Client{Hub: hub, Conn: conn, Send: make(chan []byte, 1), UserID: 1, MaxFrameSize: 16}
+		hub.Register <- c
+		c.ReadPump(func(b []byte) { got <- string(b) }, func() { got <- "too large" })
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, frame := range []string{"small", strings.Repeat("x", 17), strings.Repeat("y", 16)} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the connection survives the large frame
+	for _, want := range []string{"small", "too large", strings.Repeat("y", 16)} {
+		select {
+		case g := <-got:
+			if g != want {
+				t.Errorf("got %q, want %q", g, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
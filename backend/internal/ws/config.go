@@ -0,0 +1,30 @@
+package ws
+
+import (
+	"net/http"
+
+	"studybuddy/internal/config"
+)
+
+// hubConfig holds the settings injected from main.go
+var hubConfig = config.Default()
+
+// Configure injects the loaded config; call before accepting connections
+func Configure(cfg *config.Config) {
+	hubConfig = cfg
+}
+
+// CheckOrigin allows any origin in development and only the configured
+// CORS origins otherwise
+func CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || hubConfig.IsDev() {
+		return true
+	}
+	for _, allowed := range hubConfig.CORS.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Every server event is sent wrapped in an Envelope. The payload type is
+// determined by Type; EventTypes lists them all and drives the JSON Schema.
+//
+// New fields may be added to a payload within a protocol version. Renaming
+// or removing fields, or changing their meaning, needs a new version.
+//
+// Events in ReplayedEvents that go to a group channel are numbered with the
+// group's sequence (Seq) and kept, so reconnecting clients can catch up on
+// what they missed.
+
+// Event types
+const (
+	EventMessageCreated     = "message.created"
+	EventMessageEdited      = "message.edited"
+	EventMessageDeleted     = "message.deleted"
+	EventMessageRead        = "message.read"
+	EventMessagePinned      = "message.pinned"
+	EventMessageUnpinned    = "message.unpinned"
+	EventReadStateUpdated   = "read_state.updated"
+	EventTyping             = "typing"
+	EventPresence           = "presence"
+	EventReactionAdded      = "reaction.added"
+	EventPollUpdated        = "poll.updated"
+	EventSessionCreated     = "session.created"
+	EventResourceUploaded   = "resource.uploaded"
+	EventNotification       = "notification"
+	EventJoinRequestDecided = "join_request.decided"
+	EventRankChanged        = "rank.changed"
+	EventResync             = "resync"
+	EventSubscribed         = "subscribed"
+	EventUnsubscribed       = "unsubscribed"
+	EventError              = "error"
+)
+
+// Envelope wraps every event sent to clients
+type Envelope struct {
+	Type    string      `json:"type"`
+	Version int         `json:"version"`
+	ID      string      `json:"id"` // unique per event
+	TS      time.Time   `json:"ts"`
+	Seq     int64       `json:"seq,omitempty"` // per group, for replayed events
+	Payload interface{} `json:"payload"`
+}
+
+// ReplayedEvents are the group events clients can catch up on after a
+// reconnect. Typing, presence and read receipts are only sent live.
+var ReplayedEvents = map[string]bool{
+	EventMessageCreated:   true,
+	EventMessageEdited:    true,
+	EventMessageDeleted:   true,
+	EventMessagePinned:    true,
+	EventMessageUnpinned:  true,
+	EventReactionAdded:    true,
+	EventPollUpdated:      true,
+	EventSessionCreated:   true,
+	EventResourceUploaded: true,
+}
+
+// MessagePayload is a chat message (message.created)
+type MessagePayload struct {
+	ID           int64           `json:"id"`
+	GroupID      int             `json:"group_id"`
+	SenderID     int             `json:"sender_id"`
+	SenderName   string          `json:"sender_name"`
+	Content      string          `json:"content"`
+	MessageType  string          `json:"message_type"`
+	Payload      json.RawMessage `json:"payload,omitempty"` // file, poll, code and math messages
+	CreatedAt    time.Time       `json:"created_at"`
+	ReplyToID    *int64          `json:"reply_to_id,omitempty"`
+	ReplyTo      *QuotedPayload  `json:"reply_to,omitempty"`
+	ClientTempID string          `json:"client_temp_id,omitempty"` // echoed to the sender for deduplication
+}
+
+// QuotedPayload is the message a reply answers
+type QuotedPayload struct {
+	ID          int64  `json:"id"`
+	SenderID    int    `json:"sender_id"`
+	SenderName  string `json:"sender_name"`
+	Content     string `json:"content"`
+	MessageType string `json:"message_type"`
+}
+
+// MessageEditedPayload is the new content of an edited message
+type MessageEditedPayload struct {
+	ID       int64     `json:"id"`
+	GroupID  int       `json:"group_id"`
+	Content  string    `json:"content"`
+	EditedBy int       `json:"edited_by"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// MessageDeletedPayload turns a message into a tombstone
+type MessageDeletedPayload struct {
+	ID        int64     `json:"id"`
+	GroupID   int       `json:"group_id"`
+	DeletedBy int       `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// PinPayload is a message pinned or unpinned by a group admin
+// (message.pinned, message.unpinned)
+type PinPayload struct {
+	MessageID int64     `json:"message_id"`
+	GroupID   int       `json:"group_id"`
+	UserID    int       `json:"user_id"` // the admin
+	At        time.Time `json:"at"`
+}
+
+// MessageReadPayload is a member's new read position, sent to small groups
+// for read receipts (message.read)
+type MessageReadPayload struct {
+	GroupID           int    `json:"group_id"`
+	UserID            int    `json:"user_id"`
+	Username          string `json:"username"`
+	LastReadMessageID int64  `json:"last_read_message_id"`
+}
+
+// ReadStatePayload is the user's own read position in a group, sent to all
+// their connections (read_state.updated)
+type ReadStatePayload struct {
+	GroupID           int   `json:"group_id"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+	UnreadCount       int   `json:"unread_count"`
+}
+
+// TypingPayload tells a group that a member started or stopped typing
+type TypingPayload struct {
+	GroupID  int    `json:"group_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Typing   bool   `json:"typing"`
+}
+
+// PresencePayload tells a group that a member came online or went away
+type PresencePayload struct {
+	GroupID  int        `json:"group_id"`
+	UserID   int        `json:"user_id"`
+	Status   string     `json:"status"` // online, offline
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// ReactionPayload is a reaction added to a message
+type ReactionPayload struct {
+	MessageID    int    `json:"message_id"`
+	GroupID      int    `json:"group_id"`
+	UserID       int    `json:"user_id"`
+	ReactionType string `json:"reaction_type"`
+}
+
+// PollPayload is the new tally of a poll after a vote (poll.updated). Each
+// user's own votes are only returned to them, by the API.
+type PollPayload struct {
+	MessageID int64 `json:"message_id"`
+	GroupID   int   `json:"group_id"`
+	Counts    []int `json:"counts"` // per option
+	Voters    int   `json:"voters"`
+}
+
+// SessionPayload is a newly scheduled study session
+type SessionPayload struct {
+	ID              int       `json:"id"`
+	GroupID         int       `json:"group_id"`
+	CreatedBy       int       `json:"created_by"`
+	Title           string    `json:"title"`
+	Description     string    `json:"description"`
+	ScheduledTime   time.Time `json:"scheduled_time"`
+	DurationMinutes int       `json:"duration_minutes"`
+	VotingEnabled   bool      `json:"voting_enabled"`
+}
+
+// ResourcePayload is a file added to a group's resources
+type ResourcePayload struct {
+	ID         int    `json:"id"`
+	GroupID    int    `json:"group_id"`
+	UploadedBy int    `json:"uploaded_by"`
+	Filename   string `json:"filename"`
+	URL        string `json:"url"`
+	Size       int64  `json:"size"`
+	MimeType   string `json:"mime_type"`
+}
+
+// NotificationPayload is a notification stored for the user
+type NotificationPayload struct {
+	ID               int        `json:"id"`
+	Type             string     `json:"type"`
+	Title            string     `json:"title"`
+	Message          string     `json:"message"`
+	RelatedGroupID   *int       `json:"related_group_id,omitempty"`
+	RelatedSessionID *int       `json:"related_session_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+}
+
+// JoinRequestPayload is the decision on the user's join request
+type JoinRequestPayload struct {
+	GroupID int    `json:"group_id"`
+	Status  string `json:"status"` // approved, rejected
+	Reason  string `json:"reason,omitempty"`
+}
+
+// RankPayload is the user's new rank
+type RankPayload struct {
+	OldRank string `json:"old_rank"`
+	NewRank string `json:"new_rank"`
+}
+
+// SubscriptionPayload confirms a subscribe or unsubscribe frame. Seq is the
+// group's latest sequence number when subscribing.
+type SubscriptionPayload struct {
+	GroupID int   `json:"group_id"`
+	Seq     int64 `json:"seq,omitempty"`
+}
+
+// ResyncPayload tells a client that it missed too much to catch up: it
+// should reload the group's history and continue from Seq
+type ResyncPayload struct {
+	GroupID int   `json:"group_id"`
+	Seq     int64 `json:"seq"`
+}
+
+// ErrorPayload answers a frame that could not be handled
+type ErrorPayload struct {
+	GroupID int    `json:"group_id,omitempty"`
+	Error   string `json:"error"`
+}
+
+// EventTypes maps each event type to its payload
+var EventTypes = map[string]interface{}{
+	EventMessageCreated:     MessagePayload{},
+	EventMessageEdited:      MessageEditedPayload{},
+	EventMessageDeleted:     MessageDeletedPayload{},
+	EventMessageRead:        MessageReadPayload{},
+	EventMessagePinned:      PinPayload{},
+	EventMessageUnpinned:    PinPayload{},
+	EventReadStateUpdated:   ReadStatePayload{},
+	EventTyping:             TypingPayload{},
+	EventPresence:           PresencePayload{},
+	EventReactionAdded:      ReactionPayload{},
+	EventPollUpdated:        PollPayload{},
+	EventSessionCreated:     SessionPayload{},
+	EventResourceUploaded:   ResourcePayload{},
+	EventNotification:       NotificationPayload{},
+	EventJoinRequestDecided: JoinRequestPayload{},
+	EventRankChanged:        RankPayload{},
+	EventResync:             ResyncPayload{},
+	EventSubscribed:         SubscriptionPayload{},
+	EventUnsubscribed:       SubscriptionPayload{},
+	EventError:              ErrorPayload{},
+}
+
+// NewEnvelope wraps a payload for the current protocol version
+func NewEnvelope(eventType string, payload interface{}) Envelope {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return Envelope{
+		Type:    eventType,
+		Version: ProtocolVersion,
+		ID:      hex.EncodeToString(id),
+		TS:      time.Now().UTC(),
+		Payload: payload,
+	}
+}
+
+// DecodeEnvelope reads an encoded envelope back. The payload stays raw JSON;
+// it is only re-encoded for clients.
+func DecodeEnvelope(data []byte) (Envelope, error) {
+	var wire struct {
+		Type    string          `json:"type"`
+		Version int             `json:"version"`
+		ID      string          `json:"id"`
+		TS      time.Time       `json:"ts"`
+		Seq     int64           `json:"seq"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		Type:    wire.Type,
+		Version: wire.Version,
+		ID:      wire.ID,
+		TS:      wire.TS,
+		Seq:     wire.Seq,
+		Payload: wire.Payload,
+	}, nil
+}
+
+// Encode renders the envelope for a protocol version. Legacy connections
+// only get chat messages, as the bare payload; nil means nothing is sent.
+func (e Envelope) Encode(version int) ([]byte, error) {
+	if version == LegacyVersion {
+		if e.Type != EventMessageCreated {
+			return nil, nil
+		}
+		return json.Marshal(e.Payload)
+	}
+	e.Version = version
+	return json.Marshal(e)
+}
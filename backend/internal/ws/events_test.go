@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEnvelopeEncode(t *testing.T) {
+	e := NewEnvelope(EventMessageCreated, MessagePayload{ID: 7, GroupID: 3, Content: "hi"})
+	if e.ID == "" || e.TS.IsZero() || e.TS.Location().String() != "UTC" {
+		t.Fatalf("envelope without id or UTC timestamp: %+v", e)
+	}
+
+	data, err := e.Encode(ProtocolVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire map[string]json.RawMessage
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"type", "version", "id", "ts", "payload"} {
+		if _, ok := wire[field]; !ok {
+			t.Errorf("encoded envelope has no %q: %s", field, data)
+		}
+	}
+	// seq is only sent for numbered events
+	if _, ok := wire["seq"]; ok {
+		t.Errorf("unnumbered event has a seq: %s", data)
+	}
+	var payload MessagePayload
+	json.Unmarshal(wire["payload"], &payload)
+	if payload.ID != 7 || payload.Content != "hi" {
+		t.Errorf("payload %+v", payload)
+	}
+}
+
+func TestEnvelopeEncodeLegacy(t *testing.T) {
+	// legacy clients get chat messages as the bare payload
+	data, err := NewEnvelope(EventMessageCreated, MessagePayload{ID: 7, Content: "hi"}).Encode(LegacyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload MessagePayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.ID != 7 {
+		t.Errorf("got %s", data)
+	}
+
+	// and nothing else
+	data, err = NewEnvelope(EventTyping, TypingPayload{}).Encode(LegacyVersion)
+	if data != nil || err != nil {
+		t.Errorf("typing sent to a legacy client: %s, %v", data, err)
+	}
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	e := NewEnvelope(EventMessageDeleted, MessageDeletedPayload{ID: 9, GroupID: 3})
+	e.Seq = 42
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stored events come back with their seq and re-encode to the same frame
+	decoded, err := DecodeEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != e.Type || decoded.ID != e.ID || decoded.Seq != 42 || !decoded.TS.Equal(e.TS) {
+		t.Errorf("got %+v, want %+v", decoded, e)
+	}
+	again, err := decoded.Encode(ProtocolVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("re-encoded %s, want %s", again, data)
+	}
+
+	if _, err := DecodeEnvelope([]byte("{")); err == nil {
+		t.Error("decoded a broken frame")
+	}
+}
+
+func TestEventTypesHavePayloads(t *testing.T) {
+	for eventType := range ReplayedEvents {
+		if _, ok := EventTypes[eventType]; !ok {
+			t.Errorf("replayed event %s has no payload type", eventType)
+		}
+	}
+}
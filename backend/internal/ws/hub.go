@@ -1,78 +1,283 @@
 package ws
 
 import (
-	"encoding/json"
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
 )
 
+// Message is an event for everyone subscribed to a channel
 type Message struct {
-	GroupID string
-	Data    []byte
-	UserID  int
+	Channel string
+	Event   Envelope
 }
 
+// Subscription adds or removes a client from a channel. With CatchUp the
+// client gets no live events from the channel until the events it missed
+// are handed over with a CatchUp on Hub.CaughtUp.
+type Subscription struct {
+	Client  *Client
+	Channel string
+	CatchUp bool
+}
+
+// CatchUp hands over the events a client missed, oldest first. Live events
+// held back meanwhile follow them, except those up to Seq, which the
+// client has already got or has been told to reload.
+type CatchUp struct {
+	Client  *Client
+	Channel string
+	Events  []Envelope
+	Seq     int64
+}
+
+// Reply is an event for one client, e.g. the answer to a control frame
+type Reply struct {
+	Client *Client
+	Event  Envelope
+}
+
+// GroupChannel is the channel of a group's chat
+func GroupChannel(groupID int) string {
+	return "group:" + strconv.Itoa(groupID)
+}
+
+// ParseGroupChannel returns the group of a group channel
+func ParseGroupChannel(channel string) (int, bool) {
+	id, ok := strings.CutPrefix(channel, "group:")
+	if !ok {
+		return 0, false
+	}
+	groupID, err := strconv.Atoi(id)
+	return groupID, err == nil
+}
+
+// UserChannel is a user's personal channel. Every connection of the user is
+// subscribed to it.
+func UserChannel(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// Hub is the single registry of websocket clients, keyed by channel. One
+// connection can be subscribed to many channels. Messages are persisted by
+// the caller before they are published.
+//
+// Events are published through the broker so they reach the hubs of all
+// replicas; Broadcast only delivers to this process' clients.
 type Hub struct {
-	Clients         map[string]map[*Client]bool // GroupID → set of clients
-	Broadcast       chan Message
-	Register        chan *Client
-	Unregister      chan *Client
-	SaveMessageFunc func(msg Message) error
+	Channels    map[string]map[*Client]bool // channel → set of clients
+	Broadcast   chan Message
+	Register    chan *Client
+	Unregister  chan *Client
+	Subscribe   chan Subscription
+	Unsubscribe chan Subscription
+	Reply       chan Reply
+	CaughtUp    chan CatchUp
+
+	// OnPresence is told when a user's first connection to this replica opens
+	// (online) and when their last one closes. Set it before Run.
+	OnPresence func(userID int, online bool)
+
+	broker      Broker
+	connections map[int]int // user → open connections on this replica
+	presence    *presenceQueue
 }
 
+// NewHub creates a hub on an in-memory broker; call UseBroker to share
+// events with other replicas
 func NewHub() *Hub {
-	return &Hub{
-		Broadcast:  make(chan Message),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		Clients:    make(map[string]map[*Client]bool),
+	h := &Hub{
+		Broadcast:   make(chan Message),
+		Register:    make(chan *Client),
+		Unregister:  make(chan *Client),
+		Subscribe:   make(chan Subscription),
+		Unsubscribe: make(chan Subscription),
+		Reply:       make(chan Reply),
+		CaughtUp:    make(chan CatchUp),
+		Channels:    make(map[string]map[*Client]bool),
+		connections: make(map[int]int),
+		presence:    newPresenceQueue(),
+	}
+	h.UseBroker(NewMemoryBroker())
+	go h.presence.run(h)
+	return h
+}
+
+// UseBroker routes published events through b and broadcasts everything b
+// delivers to local clients
+func (h *Hub) UseBroker(b Broker) error {
+	if err := b.Subscribe(func(channel string, e Envelope) {
+		h.Broadcast <- Message{Channel: channel, Event: e}
+	}); err != nil {
+		return err
+	}
+	if h.broker != nil {
+		h.broker.Close()
+	}
+	h.broker = b
+	return nil
+}
+
+// Publish sends an event to the channel's subscribers on every replica
+func (h *Hub) Publish(channel string, e Envelope) error {
+	return h.broker.Publish(channel, e)
+}
+
+// PublishTx publishes an event when tx commits, if the broker can (see
+// TxBroker). ok is false when it can't; the caller then publishes after the
+// commit.
+func (h *Hub) PublishTx(tx *sql.Tx, channel string, e Envelope) (ok bool, err error) {
+	b, ok := h.broker.(TxBroker)
+	if !ok {
+		return false, nil
 	}
+	return true, b.PublishTx(tx, channel, e)
 }
 
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
-			if _, ok := h.Clients[client.GroupID]; !ok {
-				h.Clients[client.GroupID] = make(map[*Client]bool)
+			client.channels = make(map[string]bool)
+			client.catchingUp = make(map[string][]Envelope)
+			h.subscribe(client, UserChannel(client.UserID))
+			h.connections[client.UserID]++
+			if h.connections[client.UserID] == 1 {
+				h.presence.push(client.UserID, true)
 			}
-			h.Clients[client.GroupID][client] = true
 
 		case client := <-h.Unregister:
-			if clients, ok := h.Clients[client.GroupID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.Send)
-				}
-				if len(clients) == 0 {
-					delete(h.Clients, client.GroupID)
+			h.remove(client)
+
+		case sub := <-h.Subscribe:
+			// the client may have disconnected in the meantime
+			if sub.Client.channels != nil {
+				h.subscribe(sub.Client, sub.Channel)
+				if sub.CatchUp {
+					sub.Client.catchingUp[sub.Channel] = []Envelope{}
 				}
 			}
 
-		case message := <-h.Broadcast:
-			// Save message to DB
-			if h.SaveMessageFunc != nil {
-				_ = h.SaveMessageFunc(message)
-			}
+		case sub := <-h.Unsubscribe:
+			h.unsubscribe(sub.Client, sub.Channel)
 
-			// Enrich message with sender_id before broadcasting to clients
-			var msgObj map[string]interface{}
-			if err := json.Unmarshal(message.Data, &msgObj); err == nil {
-				msgObj["sender_id"] = message.UserID
-				enriched, _ := json.Marshal(msgObj)
-				message.Data = enriched
+		case reply := <-h.Reply:
+			if reply.Client.channels != nil {
+				if data := encode(reply.Event, reply.Client.Version, nil); data != nil {
+					h.send(reply.Client, data)
+				}
 			}
 
-			// Broadcast to all connected clients
-			if clients, ok := h.Clients[message.GroupID]; ok {
-				for client := range clients {
-					select {
-					case client.Send <- message.Data:
-					default:
-						close(client.Send)
-						delete(clients, client)
-					}
+		case catchUp := <-h.CaughtUp:
+			h.catchUp(catchUp)
+
+		case message := <-h.Broadcast:
+			// Broadcast to all subscribed clients, encoding once per version
+			encoded := make(map[int][]byte)
+			for client := range h.Channels[message.Channel] {
+				if held, ok := client.catchingUp[message.Channel]; ok {
+					client.catchingUp[message.Channel] = append(held, message.Event)
+					continue
+				}
+				if data := encode(message.Event, client.Version, encoded); data != nil {
+					h.send(client, data)
 				}
 			}
 
 		}
 	}
 }
+
+func (h *Hub) subscribe(c *Client, channel string) {
+	if _, ok := h.Channels[channel]; !ok {
+		h.Channels[channel] = make(map[*Client]bool)
+	}
+	h.Channels[channel][c] = true
+	c.channels[channel] = true
+}
+
+func (h *Hub) unsubscribe(c *Client, channel string) {
+	if clients, ok := h.Channels[channel]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.Channels, channel)
+		}
+	}
+	delete(c.channels, channel)
+	delete(c.catchingUp, channel)
+}
+
+// catchUp sends a client the events it missed and then those held back
+// while they were loaded; from then on the channel is live
+func (h *Hub) catchUp(cu CatchUp) {
+	c := cu.Client
+	if c.channels == nil {
+		return
+	}
+	held := c.catchingUp[cu.Channel]
+	delete(c.catchingUp, cu.Channel)
+
+	for _, e := range cu.Events {
+		if data := encode(e, c.Version, nil); data != nil {
+			h.send(c, data)
+		}
+	}
+	for _, e := range held {
+		if e.Seq != 0 && e.Seq <= cu.Seq {
+			continue
+		}
+		if data := encode(e, c.Version, nil); data != nil {
+			h.send(c, data)
+		}
+	}
+}
+
+// remove drops a client from all its channels and closes its send queue
+func (h *Hub) remove(c *Client) {
+	if c.channels == nil {
+		return
+	}
+	for channel := range c.channels {
+		h.unsubscribe(c, channel)
+	}
+	c.channels = nil
+	c.catchingUp = nil
+	close(c.Send)
+
+	h.connections[c.UserID]--
+	if h.connections[c.UserID] <= 0 {
+		delete(h.connections, c.UserID)
+		h.presence.push(c.UserID, false)
+	}
+}
+
+// send queues data for a client and drops clients that can't keep up;
+// they catch up on what they missed when they reconnect
+func (h *Hub) send(c *Client, data []byte) {
+	if c.channels == nil {
+		return
+	}
+	select {
+	case c.Send <- data:
+	default:
+		log.Printf("ws: dropping slow client of user %d", c.UserID)
+		h.remove(c)
+	}
+}
+
+// encode renders an event for a protocol version, reusing earlier results
+// from cache when given
+func encode(e Envelope, version int, cache map[int][]byte) []byte {
+	if data, ok := cache[version]; ok {
+		return data
+	}
+	data, err := e.Encode(version)
+	if err != nil {
+		log.Printf("ws encode %s: %v", e.Type, err)
+	}
+	if cache != nil {
+		cache[version] = data
+	}
+	return data
+}
@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func event(seq int64) Envelope {
+	e := NewEnvelope(EventMessageCreated, nil)
+	e.Seq = seq
+	return e
+}
+
+// received reads the seqs of the next n events sent to c
+func received(t *testing.T, c *Client, n int) []int64 {
+	t.Helper()
+	var seqs []int64
+	for len(seqs) < n {
+		select {
+		case data := <-c.Send:
+			e, err := DecodeEnvelope(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seqs = append(seqs, e.Seq)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out after %v", seqs)
+		}
+	}
+	return seqs
+}
+
+func TestCatchUpHoldsLiveEvents(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	c := &Client{Hub: hub, Send: make(chan []byte, 16), UserID: 1, Version: ProtocolVersion}
+	hub.Register <- c
+	channel := GroupChannel(7)
+	hub.Subscribe <- Subscription{Client: c, Channel: channel, CatchUp: true}
+
+	// live events published while the missed ones load; 3 is also replayed
+	for _, seq := range []int64{3, 4, 5} {
+		if err := hub.Publish(channel, event(seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case data := <-c.Send:
+		t.Fatalf("live event sent during catch-up: %s", data)
+	default:
+	}
+
+	hub.CaughtUp <- CatchUp{Client: c, Channel: channel, Events: []Envelope{event(2), event(3)}, Seq: 3}
+	hub.Publish(channel, event(6))
+
+	got := received(t, c, 5)
+	want := []int64{2, 3, 4, 5, 6}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got seqs %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCatchUpKeepsUnnumberedEvents(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	c := &Client{Hub: hub, Send: make(chan []byte, 16), UserID: 1, Version: ProtocolVersion}
+	hub.Register <- c
+	channel := GroupChannel(7)
+	hub.Subscribe <- Subscription{Client: c, Channel: channel, CatchUp: true}
+
+	// events that are not replayed have no seq and are never duplicates
+	hub.Publish(channel, event(0))
+	hub.CaughtUp <- CatchUp{Client: c, Channel: channel, Events: []Envelope{event(1)}, Seq: 1}
+
+	got := received(t, c, 2)
+	if got[0] != 1 || got[1] != 0 {
+		t.Fatalf("got seqs %v, want [1 0]", got)
+	}
+}
@@ -0,0 +1,46 @@
+package ws
+
+import "sync"
+
+type presenceChange struct {
+	userID int
+	online bool
+}
+
+// presenceQueue hands presence changes to Hub.OnPresence in order, without
+// making the hub goroutine wait for it
+type presenceQueue struct {
+	mu      sync.Mutex
+	pending []presenceChange
+	wake    chan struct{}
+}
+
+func newPresenceQueue() *presenceQueue {
+	return &presenceQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *presenceQueue) push(userID int, online bool) {
+	q.mu.Lock()
+	q.pending = append(q.pending, presenceChange{userID: userID, online: online})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *presenceQueue) run(h *Hub) {
+	for range q.wake {
+		q.mu.Lock()
+		changes := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+
+		for _, c := range changes {
+			if h.OnPresence != nil {
+				h.OnPresence(c.userID, c.online)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clients pick a protocol version by offering subprotocols named
+// "studybuddy.v<N>" (Sec-WebSocket-Protocol); the server answers with the
+// newest one it speaks. Clients that offer none get the legacy stream.
+const (
+	// LegacyVersion is the pre-envelope stream of bare chat messages
+	LegacyVersion = 0
+	// ProtocolVersion is the newest version the server speaks
+	ProtocolVersion = 1
+
+	subprotocolPrefix = "studybuddy.v"
+)
+
+// SupportedVersions lists the versions the server speaks, newest first
+var SupportedVersions = []int{1}
+
+// Subprotocol names a protocol version
+func Subprotocol(version int) string {
+	return subprotocolPrefix + strconv.Itoa(version)
+}
+
+// NegotiateVersion picks the newest supported version the client offered.
+// ok is false when the client offered versions but none we speak.
+func NegotiateVersion(r *http.Request) (version int, ok bool) {
+	offered := make(map[int]bool)
+	for _, p := range websocket.Subprotocols(r) {
+		if v, err := strconv.Atoi(strings.TrimPrefix(p, subprotocolPrefix)); err == nil && strings.HasPrefix(p, subprotocolPrefix) {
+			offered[v] = true
+		}
+	}
+	if len(offered) == 0 {
+		return LegacyVersion, true
+	}
+	for _, v := range SupportedVersions {
+		if offered[v] {
+			return v, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,39 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		offered string // Sec-WebSocket-Protocol
+		version int
+		ok      bool
+	}{
+		{"", LegacyVersion, true},
+		{"studybuddy.v1", 1, true},
+		{"studybuddy.v9, studybuddy.v1", 1, true},
+		{"studybuddy.v9", 0, false},
+		// subprotocols of other apps don't count as an offer
+		{"graphql-ws", LegacyVersion, true},
+		{"graphql-ws, studybuddy.v1", 1, true},
+		{"v1", LegacyVersion, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if tt.offered != "" {
+			r.Header.Set("Sec-WebSocket-Protocol", tt.offered)
+		}
+		version, ok := NegotiateVersion(r)
+		if version != tt.version || ok != tt.ok {
+			t.Errorf("%q: got %d, %v, want %d, %v", tt.offered, version, ok, tt.version, tt.ok)
+		}
+	}
+}
+
+func TestSubprotocol(t *testing.T) {
+	if got := Subprotocol(ProtocolVersion); got != "studybuddy.v1" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema returns a JSON Schema (draft 2020-12) describing every event of
+// the current protocol version, built from the payload structs so it can't
+// drift from the code. The frontend generates its types from it.
+func Schema() map[string]interface{} {
+	defs := make(map[string]interface{})
+
+	types := make([]string, 0, len(EventTypes))
+	for t := range EventTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	variants := make([]interface{}, 0, len(types))
+	for _, t := range types {
+		payload := schemaFor(reflect.TypeOf(EventTypes[t]), defs)
+		variants = append(variants, map[string]interface{}{
+			"properties": map[string]interface{}{
+				"type":    map[string]interface{}{"const": t},
+				"payload": payload,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         Subprotocol(ProtocolVersion) + ".json",
+		"title":       "StudyBuddy realtime event",
+		"description": "Envelope of every event sent over the " + Subprotocol(ProtocolVersion) + " websocket protocol",
+		"type":        "object",
+		"required":    []string{"type", "version", "id", "ts", "payload"},
+		"properties": map[string]interface{}{
+			"type":    map[string]interface{}{"type": "string", "enum": types},
+			"version": map[string]interface{}{"const": ProtocolVersion},
+			"id":      map[string]interface{}{"type": "string"},
+			"ts":      map[string]interface{}{"type": "string", "format": "date-time"},
+			"seq":     map[string]interface{}{"type": "integer", "description": "per-group sequence number of replayed events"},
+			"payload": map[string]interface{}{"type": "object"},
+		},
+		"oneOf": variants,
+		"$defs": defs,
+	}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaFor describes a Go type; structs are added to defs and referenced
+func schemaFor(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		inner := schemaFor(t.Elem(), defs)
+		return map[string]interface{}{"anyOf": []interface{}{inner, map[string]interface{}{"type": "null"}}}
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	// embedded JSON of any shape
+	if t == rawType {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // guards against recursive types
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	props := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type, defs)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
@@ -231,7 +231,8 @@ export default function GroupDetail() {
         }
 
         // Fetch initial messages from API
-        const msgs = await getGroupMessages(groupIdParam) || [];
+        const page = await getGroupMessages(groupIdParam);
+        const msgs = (page && page.messages) || [];
         // Normalize messages for frontend display and dedupe
         const normalized = [];
         const seenIds = new Set();
@@ -241,6 +242,8 @@ export default function GroupDetail() {
             senderID: m.sender_id || m.senderID || 0,
             senderName: m.sender_name || m.sender || (m.senderName || ""),
             content: m.content || m.message || "",
+            messageType: m.message_type || 'text',
+            payload: m.payload || null,
             createdAt: m.created_at || m.createdAt || null,
           };
           // dedupe by id
@@ -273,13 +276,17 @@ export default function GroupDetail() {
       const token = localStorage.getItem('sb_token') || '';
       const wsUrl = `${wsProto}//${apiUrl.host}/ws/${groupIdParam}?token=${token}`;
 
-      const socket = new WebSocket(wsUrl);
+      // protocol version, see backend `studybuddy events schema`
+      const socket = new WebSocket(wsUrl, ['studybuddy.v1']);
       wsRef.current = socket;
 
       // ✅ FIXED: Define message handler INSIDE this effect so it captures the latest state
       const handleMessage = (ev) => {
         try {
-          const data = JSON.parse(ev.data || '{}');
+          const event = JSON.parse(ev.data || '{}');
+          // other events (notifications, resources, ...) aren't shown here
+          if (event.type !== 'message.created') return;
+          const data = event.payload || {};
           const currentUserID = parseInt(localStorage.getItem('sb_user_id') || '0', 10);
 
           console.log('WebSocket message received:', data); // DEBUG
@@ -290,6 +297,8 @@ export default function GroupDetail() {
             senderID: data.sender_id || data.senderID || 0,
             senderName: data.sender_name || data.senderName || data.sender || 'Unknown',
             content: data.content || data.message || '',
+            messageType: data.message_type || 'text',
+            payload: data.payload || null,
             createdAt: data.created_at || data.createdAt || new Date().toISOString(),
           };
 
@@ -772,20 +781,15 @@ export default function GroupDetail() {
                       }
                     };
                     
-                    // content may be JSON for files
+                    // files are described by their payload; content is the file name
                     let content = msg.content || msg.message || '';
                     let fileMeta = null;
-                    try {
-                      const parsed = JSON.parse(content);
-                      if (parsed && parsed.type === 'file') {
-                        // Ensure URL is absolute - convert /uploads/... to API_BASE + /uploads/...
-                        if (parsed.url && !parsed.url.startsWith('http')) {
-                          parsed.url = `${API_BASE}${parsed.url}`;
-                        }
-                        fileMeta = parsed;
+                    if (msg.messageType === 'file' && msg.payload) {
+                      fileMeta = { ...msg.payload };
+                      // Ensure URL is absolute - convert /uploads/... to API_BASE + /uploads/...
+                      if (fileMeta.url && fileMeta.url.startsWith('/')) {
+                        fileMeta.url = `${API_BASE}${fileMeta.url}`;
                       }
-                    } catch (e) {
-                      // not JSON - plain text
                     }
                     // Use helper function to convert all timestamps to IST consistently
                     const timeStr = formatTimeIST(msg.createdAt);
@@ -926,13 +930,14 @@ export default function GroupDetail() {
                             clientTempId: tempId,
                             senderID: currentUserID,
                             senderName: localStorage.getItem('sb_username') || 'You',
-                            content: JSON.stringify({ 
-                              type: 'file', 
-                              filename: f.name, 
-                              url: URL.createObjectURL(f), 
+                            content: f.name,
+                            messageType: 'file',
+                            payload: {
+                              filename: f.name,
+                              url: URL.createObjectURL(f),
                               mime: f.type,
                               size: f.size
-                            }),
+                            },
                             createdAt: new Date().toISOString(),
                             isOptimistic: true,
                           };
@@ -969,6 +974,8 @@ export default function GroupDetail() {
                                       senderID: result.sender_id,
                                       senderName: result.sender_name,
                                       content: result.content,
+                                      messageType: result.message_type,
+                                      payload: result.payload,
                                       createdAt: result.created_at,
                                       clientTempId: tempId,
                                       isOptimistic: false,
@@ -188,9 +188,14 @@ export const rejectJoinRequest = (groupId, requestId, reason = '') =>
     body: JSON.stringify({ reason }),
   });
 
-// Get group messages
-export const getGroupMessages = (groupId) =>
-  apiCall(`/api/groups/${groupId}/messages`);
+// Get a page of group messages ({ messages, prev_cursor, next_cursor }).
+// params: { before, after, around, date, limit }, all optional
+export const getGroupMessages = (groupId, params = {}) => {
+  const query = new URLSearchParams(
+    Object.entries(params).filter(([, v]) => v !== undefined && v !== null && v !== '')
+  ).toString();
+  return apiCall(`/api/groups/${groupId}/messages${query ? `?${query}` : ''}`);
+};
 
 // Post a message to a group (HTTP fallback for WebSocket)
 export const postGroupMessage = (groupId, content, clientTempId) =>
@@ -199,6 +204,41 @@ export const postGroupMessage = (groupId, content, clientTempId) =>
     body: JSON.stringify({ content, clientTempId }),
   });
 
+// Post a poll, code or math message. payload is { question, options,
+// multiple_choice, closes_at }, { language, code } or { latex }
+export const postRichMessage = (groupId, messageType, payload, clientTempId) =>
+  apiCall(`/api/groups/${groupId}/messages`, {
+    method: 'POST',
+    body: JSON.stringify({ message_type: messageType, payload, clientTempId }),
+  });
+
+// Vote on a poll with option indexes; [] retracts the vote
+export const votePoll = (groupId, messageId, options) =>
+  apiCall(`/api/groups/${groupId}/messages/${messageId}/vote`, {
+    method: 'POST',
+    body: JSON.stringify({ options }),
+  });
+
+// Pinned messages of a group, most recently pinned first
+export const getGroupPins = (groupId) => apiCall(`/api/groups/${groupId}/pins`);
+
+// Pin or unpin a message (group admins only)
+export const pinMessage = (groupId, messageId, pinned = true) =>
+  apiCall(`/api/groups/${groupId}/messages/${messageId}/pin`, {
+    method: pinned ? 'POST' : 'DELETE',
+  });
+
+// Mute or unmute a group's mention and reply notifications
+export const muteGroup = (groupId, muted) =>
+  apiCall(`/api/groups/${groupId}/mute`, {
+    method: 'PUT',
+    body: JSON.stringify({ muted }),
+  });
+
+// Messages the user was mentioned in, newest first ({ mentions, next_cursor })
+export const getMyMentions = (before) =>
+  apiCall(`/api/user/mentions${before ? `?before=${before}` : ''}`);
+
 // Upload a file for a group. `file` is a File object from an <input type="file" />
 export const uploadGroupFile = async (groupId, file) => {
   const API_BASE = import.meta.env.VITE_API_URL || 'http://localhost:8080';